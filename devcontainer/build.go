@@ -0,0 +1,383 @@
+package devcontainer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// dockerSock is the host Docker socket, mounted with StartOptions.Docker.
+const dockerSock = "/var/run/docker.sock"
+
+// BuildOptions configures Build.
+type BuildOptions struct {
+	Runtime
+
+	// ImageName tags the image (default: $IMAGE_NAME, then
+	// "claude-devcontainer").
+	ImageName string
+	// NoCache builds without the Docker layer cache.
+	NoCache bool
+	// PullPolicy controls pulling the base image: "always", "missing"
+	// (default), or "never".
+	PullPolicy string
+	// BuildArgs are extra KEY=VALUE build arguments; see imageTag.
+	BuildArgs []string
+	// Quiet hides the docker build output, showing a spinner on a terminal
+	// instead. The output is still printed if the build fails.
+	Quiet bool
+}
+
+// Build (re)builds the devcontainer image from the embedded Dockerfile.
+func Build(ctx context.Context, opts BuildOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	for _, a := range opts.BuildArgs {
+		if err := validateBuildArg(a); err != nil {
+			return err
+		}
+	}
+	pullArgs, err := opts.pullPolicyArgs(ctx, opts.PullPolicy, dockerfile)
+	if err != nil {
+		return err
+	}
+	imageName := opts.ImageName
+	if imageName == "" {
+		imageName = envOrDefault("IMAGE_NAME", "claude-devcontainer")
+	}
+	return opts.buildImage(ctx, imageBuild{
+		imageName: imageTag(imageName, opts.BuildArgs),
+		noCache:   opts.NoCache,
+		pullArgs:  pullArgs,
+		buildArgs: opts.BuildArgs,
+		quiet:     opts.Quiet,
+	})
+}
+
+// UpdateResult describes a finished Update.
+type UpdateResult struct {
+	// Image is the image that was rebuilt.
+	Image string
+	// Before and After are the image IDs before and after the rebuild.
+	// Before is empty if the image didn't exist.
+	Before, After string
+}
+
+// Update rebuilds the image from scratch, pulling the latest base image and
+// ignoring the layer cache so every tool is installed afresh, then removes
+// the image it replaced. opts.NoCache and opts.PullPolicy are ignored. An
+// old image still used by a container is kept with a warning.
+func Update(ctx context.Context, opts BuildOptions) (*UpdateResult, error) {
+	imageName := opts.ImageName
+	if imageName == "" {
+		imageName = envOrDefault("IMAGE_NAME", "claude-devcontainer")
+	}
+	result := &UpdateResult{Image: imageTag(imageName, opts.BuildArgs)}
+	result.Before = opts.imageID(ctx, result.Image)
+
+	opts.NoCache = true
+	opts.PullPolicy = "always"
+	if err := Build(ctx, opts); err != nil {
+		return nil, err
+	}
+	result.After = opts.imageID(ctx, result.Image)
+
+	if result.Before != "" && result.Before != result.After {
+		if out, err := opts.command(ctx, "image", "rm", result.Before).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: keeping the old image %s: %s\n", result.Before, strings.TrimSpace(string(out)))
+		}
+	}
+	return result, nil
+}
+
+// imageID returns the ID of image, or "" if it doesn't exist.
+func (r Runtime) imageID(ctx context.Context, image string) string {
+	out, err := r.command(ctx, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// imageTag returns the image to build and run for imageName with the extra
+// build arguments buildArgs. Without any, it is imageName itself. Otherwise
+// a hash of the (sorted) arguments is appended to the tag, so images built
+// with different argument sets don't overwrite each other.
+func imageTag(imageName string, buildArgs []string) string {
+	if len(buildArgs) == 0 {
+		return imageName
+	}
+	sorted := slices.Clone(buildArgs)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\x00")))
+	suffix := "args-" + hex.EncodeToString(sum[:])[:12]
+
+	// Keep an explicit tag (name:tag), but not a registry port (host:5000/name)
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		return imageName + "-" + suffix
+	}
+	return imageName + ":" + suffix
+}
+
+// imageBuild describes one docker build of the devcontainer image.
+type imageBuild struct {
+	imageName string
+	noCache   bool
+	pullArgs  []string
+	// buildArgs are extra KEY=VALUE build arguments. They come after the
+	// computed ones, so they can override those too.
+	buildArgs []string
+	// contextDir, if set, is used as the build context instead of a temp
+	// dir holding only the embedded files.
+	contextDir string
+	// dockerfile, if set, is built instead of the embedded Dockerfile.
+	dockerfile []byte
+	// quiet captures the build output; see BuildOptions.Quiet.
+	quiet bool
+}
+
+// currentUser is user.Current, replaced in tests.
+var currentUser = user.Current
+
+// hostIDs returns the UID and GID of the host user. When user.Current
+// fails, as it can in minimal or static builds without a usable passwd
+// lookup, the process's own IDs are used instead.
+func hostIDs() (uid, gid string, err error) {
+	u, err := currentUser()
+	if err == nil {
+		return u.Uid, u.Gid, nil
+	}
+	if os.Getuid() < 0 || os.Getgid() < 0 {
+		return "", "", fmt.Errorf("getting current user: %w", err)
+	}
+	return strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid()), nil
+}
+
+// buildImage runs docker build for b, passing the host user's UID/GID and
+// the Docker socket's GID so bind mounts and the socket are accessible.
+func (r Runtime) buildImage(ctx context.Context, b imageBuild) error {
+	imageName := b.imageName
+	if imageName == "" {
+		imageName = envOrDefault("IMAGE_NAME", "claude-devcontainer")
+	}
+
+	uid, gid, err := hostIDs()
+	if err != nil {
+		return err
+	}
+
+	dockerGID := "984" // fallback
+	if info, err := os.Stat(dockerSock); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			dockerGID = strconv.FormatUint(uint64(stat.Gid), 10)
+		}
+	}
+
+	// Write embedded files to temp dir for docker build context
+	tmpDir, err := os.MkdirTemp("", "devcontainer-context-")
+	if err != nil {
+		return fmt.Errorf("creating context dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	df := b.dockerfile
+	if df == nil {
+		df = dockerfile
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "Dockerfile"), df, 0644); err != nil {
+		return fmt.Errorf("writing Dockerfile: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dockerignore"), dockerignore, 0644); err != nil {
+		return fmt.Errorf("writing .dockerignore: %w", err)
+	}
+
+	buildArgs := []string{"build",
+		"--build-arg", "USER_UID=" + uid,
+		"--build-arg", "USER_GID=" + gid,
+		"--build-arg", "DOCKER_GID=" + dockerGID,
+		"-t", imageName,
+	}
+	if b.noCache {
+		buildArgs = append(buildArgs, "--no-cache")
+	}
+	buildArgs = append(buildArgs, b.pullArgs...)
+	for _, a := range b.buildArgs {
+		buildArgs = append(buildArgs, "--build-arg", a)
+	}
+
+	// With a custom context, the Dockerfile is passed with -f.
+	// BuildKit then reads Dockerfile.dockerignore next to it instead of the
+	// context's .dockerignore, so combine the embedded patterns with the
+	// context's own.
+	if b.contextDir != "" {
+		ignore := slices.Clone(dockerignore)
+		if repoIgnore, err := os.ReadFile(filepath.Join(b.contextDir, ".dockerignore")); err == nil {
+			ignore = append(append(ignore, '\n'), repoIgnore...)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "Dockerfile.dockerignore"), ignore, 0644); err != nil {
+			return fmt.Errorf("writing Dockerfile.dockerignore: %w", err)
+		}
+		buildArgs = append(buildArgs, "-f", filepath.Join(tmpDir, "Dockerfile"), b.contextDir)
+	} else {
+		buildArgs = append(buildArgs, tmpDir)
+	}
+
+	// Frame the raw docker output, which can run for minutes on a first
+	// build with nothing saying what is going on
+	msg := "building image " + imageName
+	if r.command(ctx, "image", "inspect", imageName).Run() != nil {
+		msg += " (this may take a few minutes on first run)"
+	}
+	if !b.quiet {
+		fmt.Fprintf(os.Stderr, "devcontainer: %s...\n", msg)
+		return runCmd(ctx, r.bin(), r.cliArgs(buildArgs)...)
+	}
+
+	var out bytes.Buffer
+	cmd := r.command(ctx, buildArgs...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	stop := spin(os.Stderr, msg)
+	err = cmd.Run()
+	stop()
+	if err != nil {
+		os.Stderr.Write(out.Bytes())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// spin shows msg with a spinner on f until the returned stop is called,
+// which clears it. When f isn't a terminal, msg is printed once instead.
+func spin(f *os.File, msg string) (stop func()) {
+	if !term.IsTerminal(int(f.Fd())) {
+		fmt.Fprintf(f, "devcontainer: %s...\n", msg)
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(f, "\r%c devcontainer: %s", `|/-\`[i%4], msg)
+			select {
+			case <-done:
+				fmt.Fprint(f, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// pullPolicyArgs validates a pull policy and returns the extra docker build
+// flags implementing it for df. "never" builds with --pull=false, and fails
+// up front when df's base image, where it can be told, isn't already
+// present, rather than letting the build reach out to the registry.
+func (r Runtime) pullPolicyArgs(ctx context.Context, policy string, df []byte) ([]string, error) {
+	switch policy {
+	case "", "missing":
+		return nil, nil
+	case "always":
+		return []string{"--pull"}, nil
+	case "never":
+		// A base image named by a build argument is left to the build
+		base := baseImage(df)
+		if base != "" && !strings.Contains(base, "$") && r.command(ctx, "image", "inspect", base).Run() != nil {
+			return nil, fmt.Errorf("base image %s is not present locally and --pull-policy=never forbids pulling it", base)
+		}
+		return []string{"--pull=false"}, nil
+	default:
+		return nil, fmt.Errorf("unknown pull policy: %s (expected 'always', 'missing', or 'never')", policy)
+	}
+}
+
+// pullImage pulls ref, an image built and pushed elsewhere, according to
+// a pull policy: "always" pulls it, "missing" only if the daemon doesn't
+// have it yet, and "never" requires it to be there already.
+func (r Runtime) pullImage(ctx context.Context, ref, policy string) error {
+	present := r.command(ctx, "image", "inspect", ref).Run() == nil
+	switch policy {
+	case "", "missing":
+		if present {
+			return nil
+		}
+	case "always":
+	case "never":
+		if !present {
+			return fmt.Errorf("image %s is not present and --pull-policy=never forbids pulling it", ref)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown pull policy: %s (expected 'always', 'missing', or 'never')", policy)
+	}
+	fmt.Fprintf(os.Stderr, "devcontainer: pulling image %s...\n", ref)
+	if err := runCmd(ctx, r.bin(), r.cliArgs([]string{"pull", ref})...); err != nil {
+		return fmt.Errorf("pulling image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// checkImageIDs warns if ref, an image pulled rather than built here, was
+// built for another UID or GID than the host user's: the container user
+// then doesn't own the bind-mounted workspace and caches. Images without
+// the labels of the bundled Dockerfile aren't checked.
+func (r Runtime) checkImageIDs(ctx context.Context, ref string) {
+	out, err := r.command(ctx, "image", "inspect", "--format",
+		`{{index .Config.Labels "claude-devcontainer.uid"}}:{{index .Config.Labels "claude-devcontainer.gid"}}`, ref).Output()
+	if err != nil {
+		return
+	}
+	imageUID, imageGID, _ := strings.Cut(strings.TrimSpace(string(out)), ":")
+	uid, gid, err := hostIDs()
+	if err != nil || imageUID == "" {
+		return
+	}
+	if imageUID != uid || imageGID != gid {
+		fmt.Fprintf(os.Stderr, "devcontainer: warning: image %s was built for UID %s and GID %s, but you are %s and %s; the container user won't own the workspace and other bind mounts. Push an image built by a user with your IDs, or build it here without --registry\n", ref, imageUID, imageGID, uid, gid)
+	}
+}
+
+// baseImage returns the image named by the first FROM instruction in a
+// Dockerfile, past flags like --platform, or "" if there is none.
+func baseImage(dockerfile []byte) string {
+	scanner := bufio.NewScanner(strings.NewReader(string(dockerfile)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "--") {
+				return field
+			}
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestImageTag(t *testing.T) {
+	if got := imageTag("claude-devcontainer", nil); got != "claude-devcontainer" {
+		t.Errorf("imageTag without args = %q", got)
+	}
+
+	a := imageTag("claude-devcontainer", []string{"A=1", "B=2"})
+	if !strings.HasPrefix(a, "claude-devcontainer:args-") {
+		t.Errorf("imageTag = %q, want an args- tag", a)
+	}
+	if b := imageTag("claude-devcontainer", []string{"B=2", "A=1"}); b != a {
+		t.Errorf("argument order changed the tag: %q vs %q", a, b)
+	}
+	if c := imageTag("claude-devcontainer", []string{"A=1", "B=3"}); c == a {
+		t.Errorf("different arguments share tag %q", c)
+	}
+
+	if got := imageTag("myimage:dev", []string{"A=1"}); !strings.HasPrefix(got, "myimage:dev-args-") {
+		t.Errorf("imageTag with explicit tag = %q", got)
+	}
+	if got := imageTag("localhost:5000/img", []string{"A=1"}); !strings.HasPrefix(got, "localhost:5000/img:args-") {
+		t.Errorf("imageTag with registry port = %q", got)
+	}
+}
+
+func TestBaseImage(t *testing.T) {
+	for _, tt := range []struct {
+		dockerfile, want string
+	}{
+		{"FROM ubuntu:24.04\nRUN true\n", "ubuntu:24.04"},
+		{"# syntax=docker/dockerfile:1\nARG X\nfrom --platform=$X golang:1.24 AS build\n", "golang:1.24"},
+		{"FROM --platform=linux/amd64\n", ""},
+		{"RUN true\n", ""},
+	} {
+		if got := baseImage([]byte(tt.dockerfile)); got != tt.want {
+			t.Errorf("baseImage(%q) = %q, want %q", tt.dockerfile, got, tt.want)
+		}
+	}
+}
+
+func TestPullPolicyNever(t *testing.T) {
+	present := true
+	newFakeExec(t, func(call []string) (string, int) {
+		if !present && slices.Contains(call, "inspect") {
+			return "", 1
+		}
+		return "", 0
+	})
+	args, err := (Runtime{}).pullPolicyArgs(context.Background(), "never", []byte("FROM ubuntu:24.04\n"))
+	if err != nil || !slices.Equal(args, []string{"--pull=false"}) {
+		t.Errorf("pullPolicyArgs(never) = %q, %v, want --pull=false", args, err)
+	}
+	present = false
+	if _, err := (Runtime{}).pullPolicyArgs(context.Background(), "never", []byte("FROM ubuntu:24.04\n")); err == nil || !strings.Contains(err.Error(), "ubuntu:24.04") {
+		t.Errorf("pullPolicyArgs(never) with the base image missing = %v", err)
+	}
+}
+
+func TestCheckImageIDs(t *testing.T) {
+	uid, gid, err := hostIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+
+	for _, labels := range []string{uid + ":" + gid, ":"} {
+		newFakeExec(t, func(call []string) (string, int) { return labels + "\n", 0 })
+		(Runtime{}).checkImageIDs(context.Background(), "ghcr.io/me/claude-devcontainer")
+	}
+	if data, _ := os.ReadFile(stderr.Name()); len(data) != 0 {
+		t.Errorf("warned for matching or missing IDs: %s", data)
+	}
+	newFakeExec(t, func(call []string) (string, int) { return "12345:12345\n", 0 })
+	(Runtime{}).checkImageIDs(context.Background(), "ghcr.io/me/claude-devcontainer")
+	if data, _ := os.ReadFile(stderr.Name()); !strings.Contains(string(data), "built for UID 12345") {
+		t.Errorf("stderr = %q, want a UID mismatch warning", data)
+	}
+}
+
+func TestHostIDsFallback(t *testing.T) {
+	orig := currentUser
+	t.Cleanup(func() { currentUser = orig })
+	currentUser = func() (*user.User, error) { return nil, errors.New("user: Current requires cgo") }
+
+	uid, gid, err := hostIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != strconv.Itoa(os.Getuid()) || gid != strconv.Itoa(os.Getgid()) {
+		t.Errorf("hostIDs = %s, %s; want %d, %d", uid, gid, os.Getuid(), os.Getgid())
+	}
+}
+
+func TestBuildQuiet(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "build") {
+			return "step 1/20 failed\n", 1
+		}
+		return "", 0
+	})
+	if err := Build(context.Background(), BuildOptions{Quiet: true}); err == nil {
+		t.Error("failed build succeeded")
+	}
+	if !f.ran("docker", "image", "inspect", "claude-devcontainer") || !f.ran("docker", "build") {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	built := false
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "build"):
+			built = true
+		case slices.Contains(call, "{{.Id}}"):
+			if built {
+				return "sha256:new\n", 0
+			}
+			return "sha256:old\n", 0
+		}
+		return "", 0
+	})
+	result, err := Update(context.Background(), BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Before != "sha256:old" || result.After != "sha256:new" {
+		t.Errorf("result = %+v", result)
+	}
+	if build := f.find("build"); !slices.Contains(build, "--no-cache") || !slices.Contains(build, "--pull") {
+		t.Errorf("docker build isn't fresh: %q", build)
+	}
+	if !f.ran("docker", "image", "rm", "sha256:old") {
+		t.Errorf("old image not removed:\n%s", f)
+	}
+}
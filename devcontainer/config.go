@@ -0,0 +1,185 @@
+package devcontainer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ConfigFile is the path of the per-repository configuration, relative to
+// the workspace.
+const ConfigFile = ".devcontainer/config.json"
+
+// Config is the per-repository configuration read from ConfigFile.
+type Config struct {
+	// Profiles are named sets of start settings for common project
+	// shapes, selected with StartOptions.Profile.
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// AllowedMounts, if set, are the only host paths (and paths under
+	// them) a container may mount, e.g. so a security review can pin what
+	// leaves the host. Entries are absolute, start with ~/ for the home
+	// directory, or are relative to the repository. The workspace and its
+	// VCS metadata are always mounted; any other mount not covered, whether
+	// default or from a flag, fails the start.
+	AllowedMounts []string `json:"allowedMounts,omitempty"`
+}
+
+// Profile holds start settings that are merged with the command line. Each
+// list is prepended to the corresponding StartOptions field.
+type Profile struct {
+	Ports            []string `json:"ports,omitempty"`
+	Volumes          []string `json:"volumes,omitempty"`
+	Tmpfs            []string `json:"tmpfs,omitempty"`
+	Labels           []string `json:"labels,omitempty"`
+	BuildArgs        []string `json:"buildArgs,omitempty"`
+	MountCredentials []string `json:"mountCredentials,omitempty"`
+}
+
+// LoadConfig reads the configuration of workspace. A missing config file
+// yields an empty Config.
+func LoadConfig(workspace string) (*Config, error) {
+	path := filepath.Join(workspace, ConfigFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	var cfg Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyProfile merges the profile name into opts, keeping the profile's
+// entries ahead of those from the command line.
+func (c *Config) applyProfile(name string, opts *StartOptions) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		if len(c.Profiles) == 0 {
+			return fmt.Errorf("profile %q not found: %s defines no profiles", name, ConfigFile)
+		}
+		names := slices.Sorted(func(yield func(string) bool) {
+			for n := range c.Profiles {
+				if !yield(n) {
+					return
+				}
+			}
+		})
+		return fmt.Errorf("profile %q not found in %s (available: %s)", name, ConfigFile, strings.Join(names, ", "))
+	}
+	opts.Ports = append(slices.Clone(p.Ports), opts.Ports...)
+	opts.Volumes = append(slices.Clone(p.Volumes), opts.Volumes...)
+	opts.Tmpfs = append(slices.Clone(p.Tmpfs), opts.Tmpfs...)
+	opts.Labels = append(slices.Clone(p.Labels), opts.Labels...)
+	opts.BuildArgs = append(slices.Clone(p.BuildArgs), opts.BuildArgs...)
+	opts.MountCredentials = append(slices.Clone(p.MountCredentials), opts.MountCredentials...)
+	return nil
+}
+
+// EffectiveConfig returns the workspace's ConfigFile with the settings of
+// opts that a profile can express, after merging opts.Profile, stored as
+// that profile, so an ad-hoc invocation can be saved as a profile. The
+// profile is named opts.Profile, or "default" without one; AllowedMounts
+// and the other profiles are kept as they are.
+func EffectiveConfig(opts StartOptions) (*Config, error) {
+	workspace := opts.Workspace
+	if workspace == "" {
+		var err error
+		if workspace, err = DefaultWorkspace(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := LoadConfig(workspace)
+	if err != nil {
+		return nil, err
+	}
+	name := "default"
+	if opts.Profile != "" {
+		if err := cfg.applyProfile(opts.Profile, &opts); err != nil {
+			return nil, err
+		}
+		name = opts.Profile
+	}
+
+	credentials := slices.Clone(opts.MountCredentials)
+	if opts.MountAWS {
+		credentials = append(credentials, ".aws")
+	}
+	if opts.MountGcloud {
+		credentials = append(credentials, ".config/gcloud")
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]Profile{}
+	}
+	cfg.Profiles[name] = Profile{
+		Ports:            opts.Ports,
+		Volumes:          opts.Volumes,
+		Tmpfs:            opts.Tmpfs,
+		Labels:           opts.Labels,
+		BuildArgs:        opts.BuildArgs,
+		MountCredentials: credentials,
+	}
+	return cfg, nil
+}
+
+// checkMounts reports the mounts whose sources aren't covered by
+// c.AllowedMounts. Paths under the workspaces are always allowed; relative
+// entries are resolved against the first of them.
+func (c *Config) checkMounts(mounts []mount, homeDir string, workspaces ...string) error {
+	var allowed []string
+	for _, dir := range workspaces {
+		allowed = append(allowed, resolvePath(dir))
+	}
+	for _, entry := range c.AllowedMounts {
+		switch {
+		case entry == "~":
+			entry = homeDir
+		case strings.HasPrefix(entry, "~/"):
+			entry = filepath.Join(homeDir, entry[2:])
+		case !filepath.IsAbs(entry):
+			entry = filepath.Join(workspaces[0], entry)
+		}
+		allowed = append(allowed, resolvePath(entry))
+	}
+
+	// Compared with symlinks resolved, so a link under an allowed path
+	// can't reach outside it
+	var denied []string
+	for _, m := range mounts {
+		src := resolvePath(m.src)
+		if !slices.ContainsFunc(allowed, func(dir string) bool { return pathWithin(src, dir) }) {
+			denied = append(denied, m.src+" -> "+m.dst)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("mounts not in allowedMounts of %s:\n  %s\nadd them to allowedMounts, or leave out the flags mounting them (default mounts can be dropped with --no-ssh, --no-ssh-agent, --no-gitconfig, and --no-gh)", ConfigFile, strings.Join(denied, "\n  "))
+	}
+	return nil
+}
+
+// resolvePath returns path with its symlinks resolved, or cleaned as it
+// is if that fails, e.g. because it doesn't exist.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// pathWithin reports whether path is dir or under it.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
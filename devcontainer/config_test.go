@@ -0,0 +1,179 @@
+package devcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, workspace, data string) {
+	t.Helper()
+	os.MkdirAll(filepath.Join(workspace, ".devcontainer"), 0755)
+	if err := os.WriteFile(filepath.Join(workspace, ConfigFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := LoadConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Profiles) != 0 {
+		t.Errorf("Profiles = %v, want none", cfg.Profiles)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"profiles": {"web": {"port": ["3000:3000"]}}}`)
+	if _, err := LoadConfig(workspace); err == nil {
+		t.Error("misspelled field accepted")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"profiles": {
+		"web": {"ports": ["3000:3000", "5173:5173"], "volumes": ["/cache:/cache"]},
+		"db": {"tmpfs": ["/var/lib/db"]}
+	}}`)
+	cfg, err := LoadConfig(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := StartOptions{Ports: []string{"9229:9229"}}
+	if err := cfg.applyProfile("web", &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3000:3000", "5173:5173", "9229:9229"}; !slices.Equal(opts.Ports, want) {
+		t.Errorf("Ports = %q, want %q", opts.Ports, want)
+	}
+	if want := []string{"/cache:/cache"}; !slices.Equal(opts.Volumes, want) {
+		t.Errorf("Volumes = %q, want %q", opts.Volumes, want)
+	}
+
+	err = cfg.applyProfile("api", &StartOptions{})
+	if err == nil || !strings.Contains(err.Error(), "available: db, web") {
+		t.Errorf("applyProfile(missing) = %v, want the available profiles listed", err)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"profiles": {"web": {"ports": ["3000:3000"]}, "db": {"ports": ["5432:5432"]}}, "allowedMounts": ["~/.cargo"]}`)
+
+	cfg, err := EffectiveConfig(StartOptions{Workspace: workspace, Profile: "web", Ports: []string{"9229:9229"}, MountAWS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := cfg.Profiles["web"]
+	if !ok || len(cfg.Profiles) != 2 {
+		t.Fatalf("Profiles = %v, want web and db", cfg.Profiles)
+	}
+	if got := cfg.Profiles["db"].Ports; !slices.Equal(got, []string{"5432:5432"}) {
+		t.Errorf("db Ports = %q, want it unchanged", got)
+	}
+	if !slices.Equal(cfg.AllowedMounts, []string{"~/.cargo"}) {
+		t.Errorf("AllowedMounts = %q, want it kept", cfg.AllowedMounts)
+	}
+	if want := []string{"3000:3000", "9229:9229"}; !slices.Equal(p.Ports, want) {
+		t.Errorf("Ports = %q, want %q", p.Ports, want)
+	}
+	if want := []string{".aws"}; !slices.Equal(p.MountCredentials, want) {
+		t.Errorf("MountCredentials = %q, want %q", p.MountCredentials, want)
+	}
+
+	cfg, err = EffectiveConfig(StartOptions{Workspace: workspace, Tmpfs: []string{"/tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Profiles["default"].Tmpfs; !slices.Equal(got, []string{"/tmp"}) || len(cfg.Profiles) != 3 {
+		t.Errorf("Profiles = %+v, want default added to web and db", cfg.Profiles)
+	}
+}
+
+func TestCheckMounts(t *testing.T) {
+	cfg := &Config{AllowedMounts: []string{"~/.cargo", "/opt/shared", "vendor"}}
+	for _, tt := range []struct {
+		src     string
+		allowed bool
+	}{
+		{"/home/me/.cargo", true},
+		{"/home/me/.cargo/registry", true},
+		{"/home/me/.cargo-other", false},
+		{"/home/me/.ssh", false},
+		{"/opt/shared/data", true},
+		{"/repo/vendor/lib", true},
+		{"/repo/.devcontainer", true},
+		{"/tmp/devcontainer-x", true},
+		{"/var/run/docker.sock", false},
+	} {
+		err := cfg.checkMounts([]mount{{src: tt.src, dst: "/dst"}}, "/home/me", "/repo", "/tmp/devcontainer-x")
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkMounts(%s) = %v, want allowed %v", tt.src, err, tt.allowed)
+		}
+	}
+
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"allowedMounts": []}`)
+	cfg, err := LoadConfig(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.checkMounts([]mount{{src: "/home/me/.ssh", dst: "/home/dev/.ssh"}}, "/home/me", workspace)
+	if err == nil || !strings.Contains(err.Error(), "/home/me/.ssh -> /home/dev/.ssh") {
+		t.Errorf("empty allowlist: err = %v, want the denied mount listed", err)
+	}
+
+	// Symlinks are resolved on both sides
+	root, outside := t.TempDir(), t.TempDir()
+	os.Symlink(outside, filepath.Join(root, "escape"))
+	os.Symlink(root, filepath.Join(workspace, "root-link"))
+	cfg.AllowedMounts = []string{"root-link"}
+	if err := cfg.checkMounts([]mount{{src: filepath.Join(root, "escape"), dst: "/x"}}, "/home/me", workspace); err == nil {
+		t.Error("symlink out of an allowed path allowed")
+	}
+	if err := cfg.checkMounts([]mount{{src: root, dst: "/x"}}, "/home/me", workspace); err != nil {
+		t.Errorf("allowed path reached through a symlinked entry: %v", err)
+	}
+}
+
+func TestStartSSHAgentAllowlist(t *testing.T) {
+	f := newDetachedStart(t)
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"allowedMounts": ["~"]}`)
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true}); err == nil || !strings.Contains(err.Error(), "--no-ssh-agent") {
+		t.Errorf("Start with an agent outside allowedMounts = %v, want it denied with --no-ssh-agent suggested", err)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, NoSSHAgent: true}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); slices.Contains(run, "SSH_AUTH_SOCK=/tmp/ssh-agent.sock") {
+		t.Errorf("--no-ssh-agent still forwarded the agent: %q", run)
+	}
+}
+
+func TestStartRelativeVolumeAllowlist(t *testing.T) {
+	f := newDetachedStart(t)
+	workspace := t.TempDir()
+	writeConfig(t, workspace, `{"allowedMounts": ["~", "cache"]}`)
+
+	escape := strings.Repeat("../", strings.Count(workspace, "/")) + "etc:/host-etc"
+	_, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, Volumes: []string{escape}})
+	if err == nil || !strings.Contains(err.Error(), "/etc -> /host-etc") {
+		t.Errorf("Start(--volume %s) = %v, want it denied", escape, err)
+	}
+	if f.ran("docker", "run") {
+		t.Errorf("docker run despite a denied volume; ran:\n%s", f)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, Volumes: []string{"cache:/cache"}}); err != nil {
+		t.Errorf("Start(--volume cache:/cache) = %v, want it allowed", err)
+	}
+}
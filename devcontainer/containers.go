@@ -0,0 +1,355 @@
+package devcontainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// ContainerInfo is a devcontainer as reported by docker ps.
+type ContainerInfo struct {
+	ID        string `json:"ID"`
+	Names     string `json:"Names"`
+	Image     string `json:"Image"`
+	State     string `json:"State"`
+	Status    string `json:"Status"`
+	Ports     string `json:"Ports"`
+	CreatedAt string `json:"CreatedAt"`
+	Labels    Labels `json:"Labels"`
+}
+
+// Workspace returns the workspace the container was started from, as
+// labelled under its WorkspaceStyle.
+func (c ContainerInfo) Workspace() string {
+	return c.Labels["claude-devcontainer.workspace"]
+}
+
+// Health returns the container's health from its status: "starting",
+// "healthy", or "unhealthy", or empty without a health check.
+func (c ContainerInfo) Health() string {
+	for _, h := range []string{"health: starting", "unhealthy", "healthy"} {
+		if strings.Contains(c.Status, "("+h+")") {
+			return strings.TrimPrefix(h, "health: ")
+		}
+	}
+	return ""
+}
+
+// Created parses CreatedAt, returning the zero time if it is missing or in
+// an unexpected format.
+func (c ContainerInfo) Created() time.Time {
+	t, _ := time.Parse("2006-01-02 15:04:05 -0700 MST", c.CreatedAt)
+	return t
+}
+
+// Labels decodes the Labels field of docker ps JSON output. Docker renders
+// labels as a single "k1=v1,k2=v2" string while some compatible CLIs emit
+// an object, so both forms are accepted. The string form doesn't escape
+// commas in values, such as a workspace path, so a comma only starts the
+// next label when a label key and "=" follow it.
+type Labels map[string]string
+
+// labelStartRE matches the start of a label in docker ps's string form:
+// a key of the characters docker label keys are made of, then "=".
+var labelStartRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*=`)
+
+func (l *Labels) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err == nil {
+		*l = m
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*l = make(Labels)
+	var key string
+	for i, part := range strings.Split(str, ",") {
+		if i == 0 || labelStartRE.MatchString(part) {
+			k, v, ok := strings.Cut(part, "=")
+			if !ok {
+				continue
+			}
+			key = k
+			(*l)[key] = v
+		} else if key != "" {
+			(*l)[key] += "," + part
+		}
+	}
+	return nil
+}
+
+// ErrNoContainer is returned by ResolveContainer when no running
+// devcontainer matches.
+var ErrNoContainer = errors.New("no running devcontainer")
+
+// WorkspaceStyle selects how a workspace is identified in the
+// claude-devcontainer.workspace label. Starting and listing must use the
+// same style to match.
+type WorkspaceStyle string
+
+const (
+	// WorkspacePath labels the workspace with its path as given (the
+	// default).
+	WorkspacePath WorkspaceStyle = "path"
+	// WorkspaceCanonical resolves symlinks in the path, so the same repo
+	// reached through different symlinked paths matches.
+	WorkspaceCanonical WorkspaceStyle = "canonical"
+	// WorkspaceRemote uses the URL of the origin remote, so every clone of
+	// a repository matches.
+	WorkspaceRemote WorkspaceStyle = "remote"
+)
+
+// workspaceLabel returns the claude-devcontainer.workspace label of
+// workspace in style.
+func workspaceLabel(ctx context.Context, style WorkspaceStyle, workspace string) (string, error) {
+	switch style {
+	case "", WorkspacePath:
+		return workspace, nil
+	case WorkspaceCanonical:
+		dir, err := filepath.EvalSymlinks(workspace)
+		if err != nil {
+			return "", fmt.Errorf("resolving workspace: %w", err)
+		}
+		return dir, nil
+	case WorkspaceRemote:
+		if out, err := execCommand(ctx, "git", "-C", workspace, "remote", "get-url", "origin").Output(); err == nil {
+			return "remote:" + strings.TrimSpace(string(out)), nil
+		}
+		// A jj repository without a colocated .git
+		if out, err := execCommand(ctx, "jj", "-R", workspace, "git", "remote", "list").Output(); err == nil {
+			for _, line := range strings.Split(string(out), "\n") {
+				if name, url, ok := strings.Cut(line, " "); ok && name == "origin" {
+					return "remote:" + strings.TrimSpace(url), nil
+				}
+			}
+		}
+		return "", fmt.Errorf("cannot label workspace %s by remote: it has no origin remote", workspace)
+	}
+	return "", fmt.Errorf("unknown workspace label style: %s (expected 'path', 'canonical', or 'remote')", style)
+}
+
+// ListOptions selects devcontainers.
+type ListOptions struct {
+	Runtime
+
+	// Workspace restricts the results to containers started from this
+	// workspace. Empty lists devcontainers of every workspace.
+	Workspace string
+	// WorkspaceStyle is how Workspace was labelled when the containers
+	// were started (see StartOptions.WorkspaceStyle).
+	WorkspaceStyle WorkspaceStyle
+	// Prefix is the name prefix the containers were started with
+	// (default DefaultPrefix); see StartOptions.Prefix.
+	Prefix string
+	// All includes stopped containers, like docker ps -a.
+	All bool
+	// LabelFilters further restricts the results to containers carrying
+	// these labels, each "key=value" or just "key".
+	LabelFilters []string
+}
+
+// ListContainers returns the running devcontainers matching opts, and with
+// opts.All the stopped ones too.
+func ListContainers(ctx context.Context, opts ListOptions) ([]ContainerInfo, error) {
+	for _, f := range opts.LabelFilters {
+		if err := validateLabelFilter(f); err != nil {
+			return nil, err
+		}
+	}
+	prefix, err := namePrefix(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"ps"}
+	if opts.All {
+		args = append(args, "-a")
+	}
+	// docker's name filter matches substrings, so select devcontainers by
+	// their workspace label and match the names below
+	if opts.Workspace != "" {
+		label, err := workspaceLabel(ctx, opts.WorkspaceStyle, opts.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--filter", "label=claude-devcontainer.workspace="+label)
+	} else {
+		args = append(args, "--filter", "label=claude-devcontainer.workspace")
+	}
+	for _, f := range opts.LabelFilters {
+		args = append(args, "--filter", "label="+f)
+	}
+	args = append(args, "--format", "{{json .}}")
+
+	out, err := opts.command(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	var containers []ContainerInfo
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var ci ContainerInfo
+		if err := json.Unmarshal([]byte(line), &ci); err != nil {
+			continue
+		}
+		if devcontainerName(ci.Names, prefix) {
+			containers = append(containers, ci)
+		}
+	}
+	return containers, nil
+}
+
+// devcontainerName reports whether names (docker ps's comma-separated
+// Names) holds a devcontainer name: a worktree's prefix-<suffix>, or the
+// fixed name of a workspace without a VCS ($CONTAINER_NAME, default
+// claude-dev).
+func devcontainerName(names, prefix string) bool {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimPrefix(name, "/")
+		if strings.HasPrefix(name, prefix+"-") || name == envOrDefault("CONTAINER_NAME", "claude-dev") {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultListFormat is the template FormatContainers renders each
+// container with when none is given, as tab-separated columns under a
+// header.
+const DefaultListFormat = "{{.Names}}\t{{.Status}}\t{{.Workspace}}"
+
+// FormatContainers writes containers to w, one per line, rendering each
+// with the text/template format (like docker ps --format): its fields and
+// methods, such as {{.ID}}, {{.Labels}}, and {{.Created}}, and
+// {{index .Labels "key"}} for a single label. An empty format uses
+// DefaultListFormat.
+func FormatContainers(w io.Writer, containers []ContainerInfo, format string) error {
+	header := format == ""
+	if header {
+		format = DefaultListFormat
+	}
+	tmpl, err := template.New("list").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(tw, "NAME\tSTATUS\tWORKSPACE")
+	}
+	for _, c := range containers {
+		if err := tmpl.Execute(tw, c); err != nil {
+			return fmt.Errorf("rendering %s: %w", c.Names, err)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// ResolveContainer picks a single devcontainer matching opts and returns
+// its name. A non-empty target matches a container name with or without
+// the name prefix (e.g. "devcontainer-"), or else a container ID prefix.
+// With several candidates, including those of an ambiguous ID prefix,
+// selectMode ("first", "newest", "oldest") picks one deterministically;
+// otherwise the user is prompted. If nothing matches, the error wraps ErrNoContainer.
+func ResolveContainer(ctx context.Context, opts ListOptions, target, selectMode string) (string, error) {
+	switch selectMode {
+	case "", "first", "newest", "oldest":
+	default:
+		return "", fmt.Errorf("unknown selection: %s (expected 'first', 'newest', or 'oldest')", selectMode)
+	}
+
+	prefix, err := namePrefix(opts.Prefix)
+	if err != nil {
+		return "", err
+	}
+	containers, err := ListContainers(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", fmt.Errorf("%w found", ErrNoContainer)
+	}
+
+	if target != "" {
+		for _, c := range containers {
+			if c.Names == target || c.Names == prefix+"-"+target {
+				return c.Names, nil
+			}
+		}
+		// Otherwise a container ID prefix, as docker accepts; several
+		// matches are narrowed down like several candidates below
+		var byID []ContainerInfo
+		for _, c := range containers {
+			if strings.HasPrefix(c.ID, target) {
+				byID = append(byID, c)
+			}
+		}
+		if len(byID) == 0 {
+			return "", fmt.Errorf("%w matching %q", ErrNoContainer, target)
+		}
+		containers = byID
+	}
+
+	if len(containers) == 1 {
+		return containers[0].Names, nil
+	}
+
+	switch selectMode {
+	case "first":
+		return containers[0].Names, nil
+	case "newest", "oldest":
+		sorted := slices.Clone(containers)
+		slices.SortStableFunc(sorted, func(a, b ContainerInfo) int {
+			return a.Created().Compare(b.Created())
+		})
+		if selectMode == "newest" {
+			return sorted[len(sorted)-1].Names, nil
+		}
+		return sorted[0].Names, nil
+	}
+
+	return promptSelectContainer(containers)
+}
+
+func promptSelectContainer(containers []ContainerInfo) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("multiple devcontainers running; specify a name or run interactively")
+	}
+
+	items := make([]string, len(containers))
+	for i, c := range containers {
+		items[i] = c.Names
+	}
+
+	prompt := promptui.Select{
+		Label:  "Select a devcontainer",
+		Items:  items,
+		Stdout: os.Stderr,
+	}
+
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("selection: %w", err)
+	}
+	return containers[idx].Names, nil
+}
@@ -0,0 +1,200 @@
+package devcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListContainers(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		return `{"ID":"abc","Names":"devcontainer-x","Labels":"team=infra,claude-devcontainer.workspace=/repo"}` + "\n" +
+			`{"ID":"def","Names":"devcontainer-y","Labels":{"team":"infra"}}` + "\n", 0
+	})
+	containers, err := ListContainers(context.Background(), ListOptions{
+		Workspace:    "/repo",
+		LabelFilters: []string{"team=infra", "owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "ps",
+		"--filter", "label=claude-devcontainer.workspace=/repo",
+		"--filter", "label=team=infra",
+		"--filter", "label=owner",
+	) {
+		t.Errorf("unexpected docker ps invocation:\n%s", f)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("got %d containers, want 2", len(containers))
+	}
+	for _, c := range containers {
+		if c.Labels["team"] != "infra" {
+			t.Errorf("%s: labels = %v", c.Names, c.Labels)
+		}
+	}
+}
+
+func TestLabelsCommaInValue(t *testing.T) {
+	var c ContainerInfo
+	if err := json.Unmarshal([]byte(`{"ID":"abc","Labels":"claude-devcontainer.workspace=/src/my,repo,team=infra"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Labels{"claude-devcontainer.workspace": "/src/my,repo", "team": "infra"}); !maps.Equal(c.Labels, want) {
+		t.Errorf("labels = %v, want %v", c.Labels, want)
+	}
+	if c.Workspace() != "/src/my,repo" {
+		t.Errorf("Workspace() = %q, want the path kept whole", c.Workspace())
+	}
+}
+
+func TestListContainersNames(t *testing.T) {
+	t.Setenv("CONTAINER_NAME", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		return `{"ID":"a","Names":"devcontainer-x"}` + "\n" +
+			`{"ID":"b","Names":"claude-dev"}` + "\n" +
+			`{"ID":"c","Names":"my-devcontainer-x"}` + "\n" +
+			`{"ID":"d","Names":"claude-devtools"}` + "\n" +
+			`{"ID":"e","Names":"team-y"}` + "\n", 0
+	})
+	containers, err := ListContainers(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "ps", "--filter", "label=claude-devcontainer.workspace", "--format") {
+		t.Errorf("unexpected docker ps invocation:\n%s", f)
+	}
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(ids, want) {
+		t.Errorf("listed %q, want both naming schemes only: %q", ids, want)
+	}
+
+	containers, err = ListContainers(context.Background(), ListOptions{Prefix: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 || containers[0].ID != "b" || containers[1].ID != "e" {
+		t.Errorf("with --prefix team: %+v", containers)
+	}
+}
+
+func TestFormatContainers(t *testing.T) {
+	containers := []ContainerInfo{
+		{ID: "abc", Names: "devcontainer-x", Status: "Up 2 minutes", Labels: Labels{"claude-devcontainer.workspace": "/repo", "team": "infra"}},
+		{ID: "def", Names: "devcontainer-longer", Status: "Exited (0)"},
+	}
+	var b strings.Builder
+	if err := FormatContainers(&b, containers, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "NAME                 STATUS        WORKSPACE\n" +
+		"devcontainer-x       Up 2 minutes  /repo\n" +
+		"devcontainer-longer  Exited (0)    \n"
+	if b.String() != want {
+		t.Errorf("default format:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := FormatContainers(&b, containers, `{{.ID}} {{index .Labels "team"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if want := "abc infra\ndef \n"; b.String() != want {
+		t.Errorf("custom format = %q, want %q", b.String(), want)
+	}
+
+	if err := FormatContainers(&b, containers, "{{.ID"); err == nil {
+		t.Error("malformed template accepted")
+	}
+}
+
+func TestContainerInfoHealth(t *testing.T) {
+	for status, want := range map[string]string{
+		"Up 5 seconds (health: starting)": "starting",
+		"Up 2 minutes (healthy)":          "healthy",
+		"Up 2 minutes (unhealthy)":        "unhealthy",
+		"Up 2 minutes":                    "",
+	} {
+		if got := (ContainerInfo{Status: status}).Health(); got != want {
+			t.Errorf("Health of %q = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestListContainersInvalidFilter(t *testing.T) {
+	f := newFakeExec(t, nil)
+	if _, err := ListContainers(context.Background(), ListOptions{LabelFilters: []string{"=x"}}); err == nil {
+		t.Error("invalid label filter accepted")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("ran docker despite invalid filter:\n%s", f)
+	}
+}
+
+func TestWorkspaceLabel(t *testing.T) {
+	repo := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(repo, link)
+	canonical, _ := filepath.EvalSymlinks(repo)
+
+	newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "get-url") {
+			return "git@example.com:me/repo.git\n", 0
+		}
+		return "", 1
+	})
+	for _, tt := range []struct {
+		style WorkspaceStyle
+		want  string
+	}{
+		{"", link},
+		{WorkspacePath, link},
+		{WorkspaceCanonical, canonical},
+		{WorkspaceRemote, "remote:git@example.com:me/repo.git"},
+	} {
+		got, err := workspaceLabel(context.Background(), tt.style, link)
+		if err != nil || got != tt.want {
+			t.Errorf("workspaceLabel(%q) = %q, %v; want %q", tt.style, got, err, tt.want)
+		}
+	}
+	if _, err := workspaceLabel(context.Background(), "inode", link); err == nil {
+		t.Error("unknown style accepted")
+	}
+}
+
+func TestWorkspaceLabelNoRemote(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) { return "", 1 })
+	if _, err := workspaceLabel(context.Background(), WorkspaceRemote, t.TempDir()); err == nil {
+		t.Error("workspace without a remote labelled by remote")
+	}
+}
+
+func TestResolveContainerByID(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) {
+		return `{"ID":"abc123","Names":"devcontainer-x","CreatedAt":"2026-01-01 10:00:00 +0000 UTC"}` + "\n" +
+			`{"ID":"abd456","Names":"devcontainer-y","CreatedAt":"2026-01-02 10:00:00 +0000 UTC"}` + "\n" +
+			`{"ID":"ff0000","Names":"devcontainer-ff"}` + "\n", 0
+	})
+	for _, tt := range []struct{ target, selectMode, want string }{
+		{"abc", "", "devcontainer-x"},
+		{"abd456", "", "devcontainer-y"},
+		{"ab", "newest", "devcontainer-y"}, // ambiguous prefix
+		{"ff", "", "devcontainer-ff"},      // name wins over ID
+	} {
+		got, err := ResolveContainer(context.Background(), ListOptions{}, tt.target, tt.selectMode)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveContainer(%q, %q) = %q, %v; want %q", tt.target, tt.selectMode, got, err, tt.want)
+		}
+	}
+	if _, err := ResolveContainer(context.Background(), ListOptions{}, "123", ""); !errors.Is(err, ErrNoContainer) {
+		t.Errorf("non-prefix ID matched: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package devcontainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DockerContext is a docker context as reported by docker context ls.
+type DockerContext struct {
+	Name           string `json:"Name"`
+	Current        bool   `json:"Current"`
+	Description    string `json:"Description"`
+	DockerEndpoint string `json:"DockerEndpoint"`
+}
+
+// ListContexts returns the docker contexts configured on the host, for
+// choosing Runtime.Context.
+func ListContexts(ctx context.Context, r Runtime) ([]DockerContext, error) {
+	// The context list doesn't depend on the selected context
+	r.Context = ""
+	out, err := r.command(ctx, "context", "ls", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing docker contexts: %w", err)
+	}
+
+	var contexts []DockerContext
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var c DockerContext
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			continue
+		}
+		contexts = append(contexts, c)
+	}
+	return contexts, nil
+}
@@ -0,0 +1,34 @@
+package devcontainer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRuntimeContext(t *testing.T) {
+	f := newFakeExec(t, nil)
+	r := Runtime{Context: "builder"}
+	if _, err := ListContainers(context.Background(), ListOptions{Runtime: r}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "--context", "builder", "ps") {
+		t.Errorf("--context not passed to docker:\n%s", f)
+	}
+}
+
+func TestListContexts(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		return `{"Name":"default","Current":true,"DockerEndpoint":"unix:///var/run/docker.sock"}` + "\n" +
+			`{"Name":"builder","Current":false,"DockerEndpoint":"ssh://build@host"}` + "\n", 0
+	})
+	contexts, err := ListContexts(context.Background(), Runtime{Context: "builder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "context", "ls") {
+		t.Errorf("unexpected docker invocation:\n%s", f)
+	}
+	if len(contexts) != 2 || !contexts[0].Current || contexts[1].DockerEndpoint != "ssh://build@host" {
+		t.Errorf("contexts = %+v", contexts)
+	}
+}
@@ -0,0 +1,212 @@
+// Package devcontainer launches and manages Claude devcontainers: Docker
+// containers running Claude Code and common development tools against an
+// isolated VCS worktree of the caller's repository.
+//
+// The devcontainer CLI is a thin wrapper around this package, so editor
+// plugins and test harnesses can drive devcontainers the same way.
+package devcontainer
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed Dockerfile
+var dockerfile []byte
+
+//go:embed .dockerignore
+var dockerignore []byte
+
+// execCommand creates every external command this package runs (docker,
+// git, jj, ...). Tests replace it to record invocations instead of running
+// the real tools.
+var execCommand = exec.CommandContext
+
+// Runtime selects the docker-compatible CLI used for container operations.
+// The zero value runs "docker" and detects podman automatically.
+type Runtime struct {
+	// DockerBin is the CLI to invoke, e.g. "podman" (default "docker").
+	DockerBin string
+	// Backend forces "docker" or "podman" behavior instead of detecting it
+	// from DockerBin. Empty or "auto" detects.
+	Backend string
+	// Context is the docker context every invocation targets (see
+	// ListContexts). Empty uses the active context. Bind mounts are
+	// resolved on the context's host, so a remote context needs the
+	// workspace and home paths to exist there too.
+	Context string
+	// NoSignalForward stops relaying SIGINT and SIGTERM to attached docker
+	// run and exec processes, for debugging this package's own signal
+	// handling.
+	NoSignalForward bool
+}
+
+// Validate reports whether the runtime settings are well-formed.
+func (r Runtime) Validate() error {
+	switch r.Backend {
+	case "", "auto", "docker", "podman":
+		return nil
+	}
+	return fmt.Errorf("unknown backend: %s (expected 'auto', 'docker', or 'podman')", r.Backend)
+}
+
+func (r Runtime) bin() string {
+	if r.DockerBin == "" {
+		return "docker"
+	}
+	return r.DockerBin
+}
+
+// command returns a docker CLI invocation with args, killed if ctx is
+// done before it exits.
+func (r Runtime) command(ctx context.Context, args ...string) *exec.Cmd {
+	return execCommand(ctx, r.bin(), r.cliArgs(args)...)
+}
+
+// cliArgs prefixes args with the global flags selecting r's context.
+func (r Runtime) cliArgs(args []string) []string {
+	if r.Context == "" {
+		return args
+	}
+	return append([]string{"--context", r.Context}, args...)
+}
+
+// ContainerBackend reports whether the runtime is "docker" or "podman". An
+// explicit Backend wins; otherwise podman is recognized by the binary name
+// or by the version banner of a docker-compatible wrapper (e.g.
+// podman-docker).
+func (r Runtime) ContainerBackend(ctx context.Context) string {
+	if r.Backend == "docker" || r.Backend == "podman" {
+		return r.Backend
+	}
+	if strings.Contains(filepath.Base(r.bin()), "podman") {
+		return "podman"
+	}
+	out, err := r.command(ctx, "--version").Output()
+	if err == nil && strings.Contains(strings.ToLower(string(out)), "podman") {
+		return "podman"
+	}
+	return "docker"
+}
+
+// DefaultWorkspace returns the workspace commands operate on by default:
+// $BUILD_WORKSPACE_DIRECTORY under `bazel run`, otherwise the VCS root
+// containing the working directory.
+func DefaultWorkspace() (string, error) {
+	if dir := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("getting working directory: %w", err)
+	}
+	return findVCSRoot(dir), nil
+}
+
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// runCmd runs name with args, streaming its output. If ctx is done first,
+// the command is killed and ctx's error returned.
+func runCmd(ctx context.Context, name string, args ...string) error {
+	cmd := execCommand(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// findVCSRoot walks up from dir looking for a .jj or .git directory,
+// returning the containing directory. Returns dir unchanged if no VCS root
+// is found.
+func findVCSRoot(dir string) string {
+	cur := dir
+	for {
+		if isDir(filepath.Join(cur, ".jj")) || isDir(filepath.Join(cur, ".git")) {
+			return cur
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return dir
+		}
+		cur = parent
+	}
+}
+
+// xdgDir returns the XDG base directory named by envKey (e.g. XDG_CACHE_HOME).
+// Per the spec, unset or relative values are ignored in favor of
+// homeDir/fallback.
+func xdgDir(envKey, homeDir, fallback string) string {
+	if dir := os.Getenv(envKey); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(homeDir, fallback)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// brokenSymlink reports whether path is a symlink whose target doesn't
+// exist, returning the target. Unlike fileExists, it tells a dangling link
+// apart from a missing path.
+func brokenSymlink(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != fs.ModeSymlink {
+		return "", false
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", false
+	}
+	target, _ := os.Readlink(path)
+	return target, true
+}
+
+func isSocket(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode().Type() == fs.ModeSocket
+}
+
+// detectTimezone returns the host's IANA timezone (e.g. "America/New_York").
+// It checks TZ, /etc/timezone, then /etc/localtime in that order.
+func detectTimezone() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		return tz
+	}
+	if data, err := os.ReadFile("/etc/timezone"); err == nil {
+		if tz := strings.TrimSpace(string(data)); tz != "" {
+			return tz
+		}
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		const marker = "zoneinfo/"
+		if i := strings.LastIndex(target, marker); i != -1 {
+			return target[i+len(marker):]
+		}
+	}
+	return ""
+}
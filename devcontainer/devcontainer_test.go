@@ -0,0 +1,115 @@
+package devcontainer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExec stands in for execCommand. It records every command and, rather
+// than running it, re-executes the test binary as TestHelperProcess, which
+// prints the stdout and exits with the code respond returns for the call.
+// Output starting with fakeStderr is printed to stderr instead.
+type fakeExec struct {
+	respond func(call []string) (stdout string, exitCode int)
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+// fakeStderr starts a response that is to be printed to stderr.
+const fakeStderr = "stderr:"
+
+// fakeHang, as the exit code of a response, makes the command run until
+// it is signalled.
+const fakeHang = -1
+
+// newFakeExec installs a fakeExec for the duration of t. A nil respond
+// makes every command succeed without output.
+func newFakeExec(t *testing.T, respond func(call []string) (string, int)) *fakeExec {
+	t.Helper()
+	f := &fakeExec{respond: respond}
+	orig := execCommand
+	execCommand = f.command
+	t.Cleanup(func() { execCommand = orig })
+	return f
+}
+
+func (f *fakeExec) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	call := append([]string{name}, args...)
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	stdout, exitCode := "", 0
+	if f.respond != nil {
+		stdout, exitCode = f.respond(call)
+	}
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		"DEVCONTAINER_HELPER_PROCESS=1",
+		"DEVCONTAINER_HELPER_STDOUT="+strconv.Quote(stdout),
+		"DEVCONTAINER_HELPER_EXIT="+strconv.Itoa(exitCode),
+	)
+	return cmd
+}
+
+// ran reports whether a recorded command starts with prefix.
+func (f *fakeExec) ran(prefix ...string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if len(c) >= len(prefix) && slices.Equal(c[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the last recorded command containing arg, or nil.
+func (f *fakeExec) find(arg string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if slices.Contains(f.calls[i], arg) {
+			return f.calls[i]
+		}
+	}
+	return nil
+}
+
+// String renders the recorded commands for failure messages.
+func (f *fakeExec) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, c := range f.calls {
+		lines = append(lines, strings.Join(c, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TestHelperProcess is the process fakeExec runs in place of real commands.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("DEVCONTAINER_HELPER_PROCESS") != "1" {
+		return
+	}
+	stdout, _ := strconv.Unquote(os.Getenv("DEVCONTAINER_HELPER_STDOUT"))
+	if msg, ok := strings.CutPrefix(stdout, fakeStderr); ok {
+		fmt.Fprint(os.Stderr, msg)
+	} else {
+		fmt.Print(stdout)
+	}
+	code, _ := strconv.Atoi(os.Getenv("DEVCONTAINER_HELPER_EXIT"))
+	if code == fakeHang {
+		time.Sleep(time.Minute)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,81 @@
+package devcontainer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Doctor writes a report of the detected container backend and tool
+// availability to w, listing the stopped devcontainers named with prefix
+// (default DefaultPrefix). It returns an error if the docker CLI is
+// missing.
+func Doctor(ctx context.Context, rt Runtime, prefix string, w io.Writer) error {
+	if err := rt.Validate(); err != nil {
+		return err
+	}
+	report := func(key, value string) {
+		fmt.Fprintf(w, "%-9s %s\n", key+":", value)
+	}
+
+	path, err := exec.LookPath(rt.bin())
+	if err != nil {
+		report("backend", fmt.Sprintf("%s (not found)", rt.bin()))
+		return fmt.Errorf("container CLI %s: %w", rt.bin(), err)
+	}
+	backend := rt.ContainerBackend(ctx)
+	source := "detected"
+	if rt.Backend != "" && rt.Backend != "auto" {
+		source = "--backend"
+	}
+	report("backend", fmt.Sprintf("%s (%s, %s)", backend, path, source))
+	if out, err := rt.command(ctx, "--version").Output(); err == nil {
+		report("version", strings.TrimSpace(string(out)))
+	}
+	if out, err := rt.command(ctx, "info", "--format", "{{.ServerVersion}}").Output(); err == nil {
+		report("daemon", "reachable (server "+strings.TrimSpace(string(out))+")")
+	} else {
+		report("daemon", "unreachable")
+	}
+	if backend == "podman" {
+		report("userns", "--userns=keep-id (container UID matches the host user)")
+	} else if rt.usernsRemapped(ctx) {
+		report("userns", "daemon uses userns-remap; pass --userns host so bind mounts keep your ownership")
+	}
+
+	imageName := envOrDefault("IMAGE_NAME", "claude-devcontainer")
+	if rt.command(ctx, "image", "inspect", imageName).Run() == nil {
+		report("image", imageName+" (present)")
+	} else {
+		report("image", imageName+" (not built yet)")
+	}
+
+	// Containers kept by --keep-container or a failed --freeze, or left
+	// by a crash, with the exit code in their status
+	if containers, err := ListContainers(ctx, ListOptions{Runtime: rt, Prefix: prefix, All: true}); err == nil {
+		for _, c := range containers {
+			if c.State != "running" {
+				report("stopped", fmt.Sprintf("%s (%s); restart it with 'exec --start %s' or remove it with 'stop --rm %s'", c.Names, c.Status, c.Names, c.Names))
+			}
+		}
+	}
+
+	for _, tool := range []string{"git", "jj"} {
+		if p, err := exec.LookPath(tool); err == nil {
+			report(tool, p)
+		} else {
+			report(tool, "not found")
+		}
+	}
+	return nil
+}
+
+// usernsRemapped reports whether the docker daemon runs containers in a
+// remapped user namespace (dockerd --userns-remap), under which the host
+// UID baked into the image isn't the UID seen on bind mounts.
+func (r Runtime) usernsRemapped(ctx context.Context) bool {
+	out, err := r.command(ctx, "info", "--format", "{{json .SecurityOptions}}").Output()
+	return err == nil && strings.Contains(string(out), "name=userns")
+}
@@ -0,0 +1,43 @@
+package devcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDoctorStopped(t *testing.T) {
+	// Doctor looks the CLI up on PATH before running it
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "docker"), nil, 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CONTAINER_NAME", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") {
+			return `{"ID":"a","Names":"team-crashed","State":"exited","Status":"Exited (1) 2 minutes ago"}` + "\n" +
+				`{"ID":"b","Names":"team-busy","State":"running","Status":"Up 5 minutes"}` + "\n" +
+				`{"ID":"c","Names":"devcontainer-other","State":"exited","Status":"Exited (0) 1 hour ago"}` + "\n", 0
+		}
+		return "", 0
+	})
+	var b strings.Builder
+	if err := Doctor(context.Background(), Runtime{}, "team", &b); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "ps", "-a") {
+		t.Errorf("stopped containers not listed; ran:\n%s", f)
+	}
+	var stopped []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.HasPrefix(line, "stopped:") {
+			stopped = append(stopped, line)
+		}
+	}
+	want := []string{"stopped:  team-crashed (Exited (1) 2 minutes ago); restart it with 'exec --start team-crashed' or remove it with 'stop --rm team-crashed'"}
+	if !slices.Equal(stopped, want) {
+		t.Errorf("stopped lines = %q, want %q", stopped, want)
+	}
+}
@@ -0,0 +1,92 @@
+package devcontainer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runEditorProxy starts an editor proxy for codePath in a new temp dir and
+// returns the dir, to be mounted into the container, and a stop that shuts
+// the proxy down, giving open editors a moment, and removes the dir.
+func runEditorProxy(ctx context.Context, codePath string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "claude-editor-")
+	if err != nil {
+		return "", nil, err
+	}
+	ln, wg, err := startEditorProxy(ctx, dir, codePath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, func() {
+		ln.Close()
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// startEditorProxy listens on a Unix socket inside sharedDir and spawns a
+// handler goroutine for each connection.  The returned listener and WaitGroup
+// let the caller perform a graceful shutdown.
+func startEditorProxy(ctx context.Context, sharedDir, codePath string) (net.Listener, *sync.WaitGroup, error) {
+	sockPath := filepath.Join(sharedDir, "editor.sock")
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listening on %s: %w", sockPath, err)
+	}
+	// Allow container user to connect.
+	os.Chmod(sockPath, 0666)
+
+	var wg sync.WaitGroup
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // listener closed
+			}
+			wg.Add(1)
+			go handleEditorConn(ctx, conn, sharedDir, codePath, &wg)
+		}
+	}()
+	return ln, &wg, nil
+}
+
+// handleEditorConn reads a filename from the connection, opens it in VS Code
+// on the host, then sends "done\n" when the editor closes.
+func handleEditorConn(ctx context.Context, conn net.Conn, sharedDir, codePath string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() {
+		return
+	}
+	filename := scanner.Text()
+
+	// Sanitize: reject path traversal
+	if strings.Contains(filename, "/") || strings.Contains(filename, "\\") || filename == ".." {
+		return
+	}
+
+	filePath := filepath.Join(sharedDir, filename)
+	cmd := execCommand(ctx, codePath, "--wait", filePath)
+	cmd.Stdout = os.Stderr // surface VS Code output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "devcontainer: code --wait failed: %v\n", err)
+	}
+
+	conn.Write([]byte("done\n"))
+}
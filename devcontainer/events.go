@@ -0,0 +1,85 @@
+package devcontainer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// lifecycleEvents are the docker events relayed by StartOptions.Events.
+var lifecycleEvents = []string{"create", "start", "die"}
+
+// eventsGrace is how long an event watch waits for the die event after the
+// container has exited before giving up on it.
+var eventsGrace = 2 * time.Second
+
+// openEvents opens the destination of StartOptions.Events: "-" for stderr
+// or a file, appended to so several runs can share it.
+func openEvents(dest string) (io.WriteCloser, error) {
+	if dest == "-" {
+		return nopCloser{os.Stderr}, nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening --events file: %w", err)
+	}
+	return f, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// watchEvents relays the lifecycle events of container name to w as JSON
+// Lines until the returned stop is called. stop waits briefly for the die
+// event, which docker may deliver after the container's run has returned,
+// then ends the subscription. Events from the time of the call are
+// replayed, so the ones of a container started before the subscription is
+// up aren't missed.
+func (r Runtime) watchEvents(ctx context.Context, name string, w io.Writer) (stop func(), err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	since := time.Now()
+	args := []string{"events", "--since", fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()), "--filter", "type=container", "--filter", "container=" + name}
+	for _, e := range lifecycleEvents {
+		args = append(args, "--filter", "event="+e)
+	}
+	args = append(args, "--format", "{{json .}}")
+	cmd := r.command(ctx, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("watching docker events: %w", err)
+	}
+
+	// finished closes at the die event or when the subscription ends
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			fmt.Fprintln(w, scanner.Text())
+			var e struct{ Status, Action string }
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && (e.Action == "die" || e.Status == "die") {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		select {
+		case <-finished:
+		case <-time.After(eventsGrace):
+		}
+		cancel()
+		<-finished
+		cmd.Wait()
+	}, nil
+}
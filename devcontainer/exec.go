@@ -0,0 +1,227 @@
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ExecOptions configures Exec.
+type ExecOptions struct {
+	// ListOptions scopes the containers Target is resolved against.
+	ListOptions
+
+	// Target names the container to attach to; see ResolveContainer.
+	Target string
+	// Select picks among several candidates without prompting: "first",
+	// "newest", or "oldest".
+	Select string
+	// NameSuffix tells concurrent sessions in the same container apart:
+	// the session is named <container>-<suffix> in messages and in the
+	// shell's DEVCONTAINER_SESSION variable.
+	NameSuffix string
+	// Start, when no running devcontainer matches, looks among the stopped
+	// ones and starts the match before attaching.
+	Start bool
+	// NoLogin runs a plain bash instead of a login shell, which skips
+	// ~/.bash_profile and ~/.profile.
+	NoLogin bool
+	// Script, if set, is a host file copied into the container and run
+	// with bash instead of opening a shell; Exec returns the exit code of
+	// its last command. The copy is removed afterwards.
+	Script string
+	// FailFast runs Script with bash -e, stopping at the first failing
+	// command.
+	FailFast bool
+}
+
+// Exec opens a bash shell in a running devcontainer, or runs opts.Script
+// there, and returns the exit code once it ends.
+func Exec(ctx context.Context, opts ExecOptions) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	if err := validateNameSuffix(opts.NameSuffix); err != nil {
+		return 0, err
+	}
+	if opts.FailFast && opts.Script == "" {
+		return 0, fmt.Errorf("--fail-fast requires --script")
+	}
+	if opts.Script != "" && !fileExists(opts.Script) {
+		return 0, fmt.Errorf("--script %s does not exist", opts.Script)
+	}
+	name, err := ResolveContainer(ctx, opts.ListOptions, opts.Target, opts.Select)
+	if errors.Is(err, ErrNoContainer) && opts.Start {
+		name, err = opts.startStopped(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	session := ""
+	if opts.NameSuffix != "" {
+		session = name + "-" + opts.NameSuffix
+		fmt.Fprintf(os.Stderr, "devcontainer: attaching to %s as session %s\n", name, session)
+	}
+	if opts.Script != "" {
+		return opts.execScript(ctx, name, session, !opts.NoLogin)
+	}
+	return opts.execShell(ctx, name, session, !opts.NoLogin)
+}
+
+// execScript copies opts.Script to a temp file in the container name, runs
+// it with bash like execShell runs the shell, and removes it again.
+func (opts ExecOptions) execScript(ctx context.Context, name, session string, login bool) (int, error) {
+	out, err := opts.command(ctx, "exec", name, "mktemp", "/tmp/devcontainer-script-XXXXXX").Output()
+	if err != nil {
+		return 0, fmt.Errorf("creating script file in %s: %w", name, err)
+	}
+	dst := strings.TrimSpace(string(out))
+	// docker cp leaves the copy owned by root, so remove it as root
+	defer func() {
+		if out, err := opts.command(context.WithoutCancel(ctx), "exec", "-u", "root", name, "rm", "-f", dst).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: removing %s from %s: %s\n", dst, name, strings.TrimSpace(string(out)))
+		}
+	}()
+	if out, err := opts.command(ctx, "cp", opts.Script, name+":"+dst).CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("copying %s into %s: %s", opts.Script, name, strings.TrimSpace(string(out)))
+	}
+
+	var bashArgs []string
+	if opts.FailFast {
+		bashArgs = append(bashArgs, "-e")
+	}
+	return opts.execBash(ctx, name, session, login, append(bashArgs, dst)...)
+}
+
+// startStopped starts the stopped devcontainer matching opts and returns
+// its name.
+func (opts ExecOptions) startStopped(ctx context.Context) (string, error) {
+	all := opts.ListOptions
+	all.All = true
+	name, err := ResolveContainer(ctx, all, opts.Target, opts.Select)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "devcontainer: starting stopped container %s\n", name)
+	if out, err := opts.command(ctx, "start", name).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("starting %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	return name, nil
+}
+
+// execShell runs an interactive bash shell in the running container name,
+// as a login shell if login is set so PATH and tool setup from the profile
+// scripts apply. A non-empty session is exported as DEVCONTAINER_SESSION.
+// Each call is a separate docker exec with its own TTY, so any number of
+// shells can share a container.
+func (r Runtime) execShell(ctx context.Context, name, session string, login bool) (int, error) {
+	return r.execBash(ctx, name, session, login)
+}
+
+// execBash runs bash with args in the container name, attached like
+// execShell.
+func (r Runtime) execBash(ctx context.Context, name, session string, login bool, args ...string) (int, error) {
+	dockerArgs := []string{"exec", "-i"}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		dockerArgs = append(dockerArgs, "-t")
+	}
+	if session != "" {
+		dockerArgs = append(dockerArgs, "-e", "DEVCONTAINER_SESSION="+session)
+	}
+	dockerArgs = append(dockerArgs, name, "bash")
+	if login {
+		dockerArgs = append(dockerArgs, "-l")
+	}
+	dockerArgs = append(dockerArgs, args...)
+
+	ctx = r.attachedContext(ctx)
+	dockerCmd := r.command(ctx, dockerArgs...)
+	dockerCmd.Stdin = os.Stdin
+	dockerCmd.Stdout = os.Stdout
+	dockerCmd.Stderr = os.Stderr
+	return r.runForwardingSignals(ctx, dockerCmd, "docker exec", nil)
+}
+
+// attachedContext returns the context to run an attached docker run or
+// exec on, to be passed to runForwardingSignals along with it. The signals
+// it forwards are the container's to handle, so the cancellation of ctx
+// they cause (see the devcontainer command's main) must not stop docker
+// too and lose the container's exit code; only ctx's values carry over.
+// With r.NoSignalForward, the signals are left to ctx instead.
+func (r Runtime) attachedContext(ctx context.Context) context.Context {
+	if r.NoSignalForward {
+		return ctx
+	}
+	return context.WithoutCancel(ctx)
+}
+
+// runForwardingSignals runs cmd, relaying SIGINT and SIGTERM to it so the
+// container rather than this process handles them, and returns its exit
+// code. If kill is set, a second signal calls it instead, to force a slow
+// shutdown. If ctx is done first, cmd gets SIGTERM (and is killed if it
+// hasn't exited cmd.WaitDelay, by default 10 seconds, later) and ctx's
+// error is returned. what names the command in errors. With
+// r.NoSignalForward the signals are left to this process instead.
+func (r Runtime) runForwardingSignals(ctx context.Context, cmd *exec.Cmd, what string, kill func()) (int, error) {
+	sigCh := make(chan os.Signal, 1)
+	if !r.NoSignalForward {
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+	defer func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}()
+
+	// docker run and docker exec relay SIGTERM to the container, which
+	// gets a chance to shut down cleanly before being killed.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = 10 * time.Second
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("starting %s: %w", what, err)
+	}
+
+	go relaySignals(sigCh, func(sig os.Signal) { cmd.Process.Signal(sig) }, kill)
+
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("running %s: %w", what, ctx.Err())
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, fmt.Errorf("running %s: %w", what, err)
+	}
+	return 0, nil
+}
+
+// relaySignals forwards the signals from sigCh until it is closed. With
+// kill, only the first is forwarded and the next ones call kill.
+func relaySignals(sigCh <-chan os.Signal, forward func(os.Signal), kill func()) {
+	interrupted := false
+	for sig := range sigCh {
+		if interrupted && kill != nil {
+			fmt.Fprintln(os.Stderr, "devcontainer: interrupted again; killing the container")
+			kill()
+			continue
+		}
+		forward(sig)
+		if !interrupted && kill != nil {
+			fmt.Fprintln(os.Stderr, "devcontainer: waiting for the container to stop; interrupt again to kill it")
+		}
+		interrupted = true
+	}
+}
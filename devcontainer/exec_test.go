@@ -0,0 +1,128 @@
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestExecNameSuffix(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") {
+			return `{"ID":"abc","Names":"devcontainer-x"}` + "\n", 0
+		}
+		return "", 0
+	})
+	if _, err := Exec(context.Background(), ExecOptions{NameSuffix: "tests"}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "exec", "-i", "-e", "DEVCONTAINER_SESSION=devcontainer-x-tests", "devcontainer-x", "bash", "-l") {
+		t.Errorf("session not named; ran:\n%s", f)
+	}
+
+	if _, err := Exec(context.Background(), ExecOptions{NameSuffix: "a b"}); err == nil {
+		t.Error("invalid --name-suffix accepted")
+	}
+}
+
+func TestExecStartStopped(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") && slices.Contains(call, "-a") {
+			return `{"ID":"abc","Names":"devcontainer-x","Status":"Exited (0) 1 hour ago"}` + "\n", 0
+		}
+		return "", 0
+	})
+	if _, err := Exec(context.Background(), ExecOptions{}); err == nil {
+		t.Error("attached to a stopped container without --start")
+	}
+	if _, err := Exec(context.Background(), ExecOptions{Start: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "start", "devcontainer-x") || !f.ran("docker", "exec", "-i", "devcontainer-x") {
+		t.Errorf("stopped container not started and attached; ran:\n%s", f)
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "ps"):
+			return `{"ID":"abc","Names":"devcontainer-x"}` + "\n", 0
+		case slices.Contains(call, "mktemp"):
+			return "/tmp/devcontainer-script-abc123\n", 0
+		case slices.Contains(call, "bash"):
+			return "", 3
+		}
+		return "", 0
+	})
+	script := filepath.Join(t.TempDir(), "setup.sh")
+	os.WriteFile(script, []byte("make deps\nmake test\n"), 0644)
+
+	code, err := Exec(context.Background(), ExecOptions{Script: script, FailFast: true, NoLogin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	for _, want := range [][]string{
+		{"docker", "cp", script, "devcontainer-x:/tmp/devcontainer-script-abc123"},
+		{"docker", "exec", "-i", "devcontainer-x", "bash", "-e", "/tmp/devcontainer-script-abc123"},
+		{"docker", "exec", "-u", "root", "devcontainer-x", "rm", "-f", "/tmp/devcontainer-script-abc123"},
+	} {
+		if !f.ran(want...) {
+			t.Errorf("did not run %q; ran:\n%s", want, f)
+		}
+	}
+
+	if _, err := Exec(context.Background(), ExecOptions{FailFast: true}); err == nil {
+		t.Error("--fail-fast without --script accepted")
+	}
+}
+
+func TestRelaySignals(t *testing.T) {
+	sigCh := make(chan os.Signal, 3)
+	sigCh <- syscall.SIGINT
+	sigCh <- syscall.SIGINT
+	sigCh <- syscall.SIGTERM
+	close(sigCh)
+	var forwarded []os.Signal
+	kills := 0
+	relaySignals(sigCh, func(sig os.Signal) { forwarded = append(forwarded, sig) }, func() { kills++ })
+	if !slices.Equal(forwarded, []os.Signal{syscall.SIGINT}) || kills != 2 {
+		t.Errorf("forwarded %v and killed %d times, want only the first forwarded", forwarded, kills)
+	}
+
+	sigCh = make(chan os.Signal, 2)
+	sigCh <- syscall.SIGINT
+	sigCh <- syscall.SIGINT
+	close(sigCh)
+	forwarded = nil
+	relaySignals(sigCh, func(sig os.Signal) { forwarded = append(forwarded, sig) }, nil)
+	if len(forwarded) != 2 {
+		t.Errorf("without kill, forwarded %v, want every signal", forwarded)
+	}
+}
+
+func TestAttachedContext(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) { return "", 130 })
+	// As the first Ctrl-C does, which the container gets too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := Runtime{}
+	runCtx := r.attachedContext(ctx)
+	code, err := r.runForwardingSignals(runCtx, r.command(runCtx, "exec", "devcontainer-x"), "docker exec", nil)
+	if err != nil || code != 130 {
+		t.Errorf("runForwardingSignals = %d, %v; want the container's exit code 130", code, err)
+	}
+
+	r.NoSignalForward = true
+	runCtx = r.attachedContext(ctx)
+	if _, err := r.runForwardingSignals(runCtx, r.command(runCtx, "exec", "devcontainer-x"), "docker exec", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("with NoSignalForward, runForwardingSignals error = %v, want ctx's", err)
+	}
+}
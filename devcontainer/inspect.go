@@ -0,0 +1,151 @@
+package devcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// InspectOptions configures Inspect.
+type InspectOptions struct {
+	// ListOptions scopes the containers Target is resolved against.
+	ListOptions
+
+	// Target names the container to inspect; see ResolveContainer.
+	Target string
+	// Select picks among several candidates without prompting: "first",
+	// "newest", or "oldest".
+	Select string
+	// Raw writes docker inspect's JSON as-is instead of the summary.
+	Raw bool
+}
+
+// inspectedContainer is the part of docker inspect's output the summary
+// shows.
+type inspectedContainer struct {
+	Name    string `json:"Name"`
+	Created string `json:"Created"`
+	Config  struct {
+		Image      string            `json:"Image"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status string `json:"Status"`
+		Health *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Mounts []inspectedMount `json:"Mounts"`
+}
+
+// inspectedMount is a mount of a container in docker inspect's output.
+type inspectedMount struct {
+	Type        string `json:"Type"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	RW          bool   `json:"RW"`
+}
+
+// Inspect resolves a devcontainer and writes a summary of it to w: its
+// claude-devcontainer.* labels, the host directory mounted as its
+// workspace and, for a git worktree, the branch checked out there, then
+// its other labels and its mounts. With opts.Raw it writes docker
+// inspect's output instead.
+func Inspect(ctx context.Context, opts InspectOptions, w io.Writer) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	name, err := ResolveContainer(ctx, opts.ListOptions, opts.Target, opts.Select)
+	if err != nil {
+		return err
+	}
+	out, err := opts.command(ctx, "inspect", name).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting container %s: %w", name, err)
+	}
+	if opts.Raw {
+		_, err := w.Write(out)
+		return err
+	}
+
+	var containers []inspectedContainer
+	if err := json.Unmarshal(out, &containers); err != nil {
+		return fmt.Errorf("parsing docker inspect output for %s: %w", name, err)
+	}
+	if len(containers) != 1 {
+		return fmt.Errorf("docker inspect returned %d containers for %s", len(containers), name)
+	}
+	c := containers[0]
+
+	report := func(key, value string) {
+		fmt.Fprintf(w, "%-10s %s\n", key+":", value)
+	}
+	report("name", strings.TrimPrefix(c.Name, "/"))
+	report("image", c.Config.Image)
+	status := c.State.Status
+	if c.State.Health != nil && c.State.Health.Status != "" {
+		status += " (" + c.State.Health.Status + ")"
+	}
+	report("status", status)
+	report("created", c.Created)
+	report("workspace", c.Config.Labels["claude-devcontainer.workspace"])
+	for _, m := range c.Mounts {
+		if m.Destination != c.Config.WorkingDir {
+			continue
+		}
+		report("source", m.Source+" (at "+m.Destination+")")
+		// A worktree's branch, if the source is still there to ask
+		if out, err := execCommand(ctx, "git", "-C", m.Source, "branch", "--show-current").Output(); err == nil {
+			if branch := strings.TrimSpace(string(out)); branch != "" {
+				report("branch", branch)
+			}
+		}
+		break
+	}
+
+	var ours, others []string
+	for k, v := range c.Config.Labels {
+		if strings.HasPrefix(k, "claude-devcontainer.") {
+			ours = append(ours, k+"="+v)
+		} else {
+			others = append(others, k+"="+v)
+		}
+	}
+	slices.Sort(ours)
+	slices.Sort(others)
+	for _, section := range []struct {
+		title  string
+		labels []string
+	}{{"devcontainer labels", ours}, {"other labels", others}} {
+		if len(section.labels) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, l := range section.labels {
+			fmt.Fprintf(w, "  %s\n", l)
+		}
+	}
+
+	if len(c.Mounts) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\nmounts:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, m := range c.Mounts {
+		mode := "rw"
+		if !m.RW {
+			mode = "ro"
+		}
+		source := m.Source
+		if m.Type != "" && m.Type != "bind" {
+			source = m.Type + ":" + source
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", source, m.Destination, mode)
+	}
+	return tw.Flush()
+}
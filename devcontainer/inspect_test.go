@@ -0,0 +1,68 @@
+package devcontainer
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	const inspected = `[{
+		"Name": "/devcontainer-x",
+		"Created": "2026-01-02T03:04:05Z",
+		"Config": {
+			"Image": "claude-devcontainer",
+			"WorkingDir": "/repo",
+			"Labels": {"claude-devcontainer.workspace": "/repo", "claude-devcontainer.memory": "4g", "team": "infra"}
+		},
+		"State": {"Status": "running", "Health": {"Status": "healthy"}},
+		"Mounts": [
+			{"Type": "bind", "Source": "/repo/.worktrees/devcontainer-1", "Destination": "/repo", "RW": true},
+			{"Type": "bind", "Source": "/home/u/.gitconfig", "Destination": "/home/u/.gitconfig", "RW": false}
+		]
+	}]`
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "ps"):
+			return `{"ID":"abc","Names":"devcontainer-x"}` + "\n", 0
+		case slices.Contains(call, "inspect"):
+			return inspected, 0
+		case slices.Contains(call, "branch"):
+			return "devcontainer-1\n", 0
+		}
+		return "", 0
+	})
+
+	var out bytes.Buffer
+	if err := Inspect(context.Background(), InspectOptions{ListOptions: ListOptions{Prefix: "devcontainer"}, Target: "x"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("docker", "inspect", "devcontainer-x") || !f.ran("git", "-C", "/repo/.worktrees/devcontainer-1", "branch", "--show-current") {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"name:      devcontainer-x\n",
+		"status:    running (healthy)\n",
+		"workspace: /repo\n",
+		"source:    /repo/.worktrees/devcontainer-1 (at /repo)\n",
+		"branch:    devcontainer-1\n",
+		"devcontainer labels:\n  claude-devcontainer.memory=4g\n  claude-devcontainer.workspace=/repo\n",
+		"other labels:\n  team=infra\n",
+		"/home/u/.gitconfig",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary lacks %q:\n%s", want, got)
+		}
+	}
+
+	out.Reset()
+	if err := Inspect(context.Background(), InspectOptions{ListOptions: ListOptions{Prefix: "devcontainer"}, Target: "x", Raw: true}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != inspected {
+		t.Errorf("--raw output = %q, want docker's output as-is", out.String())
+	}
+}
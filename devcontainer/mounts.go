@@ -0,0 +1,680 @@
+package devcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// devHome is the default home directory of the container user; see
+// StartOptions.ContainerHome.
+const devHome = "/home/dev"
+
+// mount is a bind mount of a host path into the container.
+type mount struct {
+	src, dst string
+	ro       bool
+}
+
+// arg renders m as a docker run -v value.
+func (m mount) arg() string {
+	if m.ro {
+		return m.src + ":" + m.dst + ":ro"
+	}
+	return m.src + ":" + m.dst
+}
+
+// candidate is a mount that is made unless skip says why not.
+type candidate struct {
+	mount
+	skip string
+}
+
+// danglingSkip starts the skip reason of a candidate whose source is a
+// dangling symlink, followed by the link's target.
+const danglingSkip = "symlink to missing "
+
+// homeCandidates returns the mounts of toolchains, caches, and
+// configuration from the host user's home into containerHome, including
+// those left out and why: configuration that can be absent is only mounted
+// when present.
+func homeCandidates(homeDir, containerHome string) []candidate {
+	cacheHome := xdgDir("XDG_CACHE_HOME", homeDir, ".cache")
+	configHome := xdgDir("XDG_CONFIG_HOME", homeDir, ".config")
+
+	var candidates []candidate
+	always := func(m mount) {
+		candidates = append(candidates, candidate{mount: m})
+	}
+	ifPresent := func(m mount, present func(string) bool) {
+		c := candidate{mount: m}
+		if !present(m.src) {
+			c.skip = "not present"
+		}
+		candidates = append(candidates, c)
+	}
+
+	always(mount{filepath.Join(cacheHome, "bazelisk"), containerHome + "/.cache/bazelisk", true})
+	always(mount{filepath.Join(homeDir, ".cargo"), containerHome + "/.cargo", false})
+	always(mount{filepath.Join(homeDir, ".rustup"), containerHome + "/.rustup", true})
+	always(mount{filepath.Join(homeDir, "go"), containerHome + "/go", true})
+	always(mount{filepath.Join(homeDir, "dev/go"), containerHome + "/gopath", false})
+	always(mount{filepath.Join(homeDir, ".npm"), containerHome + "/.npm", false})
+	always(mount{filepath.Join(cacheHome, "pnpm"), containerHome + "/.cache/pnpm", true})
+	always(mount{filepath.Join(homeDir, ".claude"), containerHome + "/.claude", false})
+	always(mount{filepath.Join(homeDir, ".claude.json"), containerHome + "/.claude.json", false})
+
+	// Conditional mounts
+	ifPresent(mount{filepath.Join(homeDir, ".gitconfig"), containerHome + "/.gitconfig", true}, fileExists)
+	ifPresent(mount{filepath.Join(configHome, "gh"), containerHome + "/.config/gh", true}, isDir)
+	ifPresent(mount{filepath.Join(configHome, "jj"), containerHome + "/.config/jj", true}, isDir)
+	// Version manager configuration (asdf, mise and its former name rtx)
+	// so the container picks the same tool versions.
+	for _, f := range []string{".tool-versions", ".asdfrc"} {
+		ifPresent(mount{filepath.Join(homeDir, f), containerHome + "/" + f, true}, fileExists)
+	}
+	for _, d := range []string{"mise", "rtx"} {
+		ifPresent(mount{filepath.Join(configHome, d), containerHome + "/.config/" + d, true}, isDir)
+	}
+	ifPresent(mount{filepath.Join(homeDir, ".ssh"), containerHome + "/.ssh", true}, isDir)
+
+	// A source that is a dangling symlink (e.g. ~/go pointing at an
+	// unmounted drive) can't be bound
+	for i, c := range candidates {
+		if target, ok := brokenSymlink(c.src); ok {
+			candidates[i].skip = danglingSkip + target
+		}
+	}
+	return candidates
+}
+
+// credentialCandidates returns read-only mounts for the credential paths
+// in credentials, including missing ones. Relative paths are under
+// homeDir; paths under homeDir land at the same place under containerHome,
+// others at the same absolute path.
+func credentialCandidates(homeDir, containerHome string, credentials []string) []candidate {
+	var candidates []candidate
+	for _, c := range credentials {
+		src, dst := c, c
+		if !filepath.IsAbs(c) {
+			src = filepath.Join(homeDir, c)
+		}
+		if rel, err := filepath.Rel(homeDir, src); err == nil && !strings.HasPrefix(rel, "..") {
+			dst = containerHome + "/" + filepath.ToSlash(rel)
+		}
+		candidate := candidate{mount: mount{src, dst, true}}
+		if !fileExists(src) {
+			candidate.skip = "not found"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// gitCredentialCandidates returns read-only mounts of git's credential
+// store (~/.git-credentials) and credential cache daemon directory, left
+// out if absent, at their default places under containerHome, so git in
+// the container finds them with the host's credential.helper settings.
+func gitCredentialCandidates(homeDir, containerHome string) []candidate {
+	cacheHome := xdgDir("XDG_CACHE_HOME", homeDir, ".cache")
+	var candidates []candidate
+	for _, c := range []struct {
+		m       mount
+		present func(string) bool
+	}{
+		{mount{filepath.Join(homeDir, ".git-credentials"), containerHome + "/.git-credentials", true}, fileExists},
+		// The cache daemon's socket is in a directory of its own, mounted
+		// whole so a daemon restarted with a new socket is still reached
+		{mount{filepath.Join(cacheHome, "git", "credential"), containerHome + "/.cache/git/credential", true}, isDir},
+		{mount{filepath.Join(homeDir, ".git-credential-cache"), containerHome + "/.git-credential-cache", true}, isDir},
+	} {
+		candidate := candidate{mount: c.m}
+		if !c.present(c.m.src) {
+			candidate.skip = "not present"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// goCacheVars are the go env variables of the caches --go-cache mounts,
+// with the directory under the container's ~/.cache each is mounted at.
+var goCacheVars = []struct{ name, dir string }{
+	{"GOMODCACHE", "go-mod"},
+	{"GOCACHE", "go-build"},
+}
+
+// goCacheCandidates returns the host's Go module and build caches, as go
+// env reports them, as writable mounts in the order of goCacheVars.
+func goCacheCandidates(ctx context.Context, containerHome string) []candidate {
+	out, err := execCommand(ctx, "go", "env", "GOMODCACHE", "GOCACHE").Output()
+	values := strings.Split(strings.TrimSpace(string(out)), "\n")
+	var candidates []candidate
+	for i, v := range goCacheVars {
+		c := candidate{mount: mount{dst: containerHome + "/.cache/" + v.dir}}
+		switch {
+		case err != nil:
+			c.skip = "go env failed"
+		case i >= len(values) || !filepath.IsAbs(strings.TrimSpace(values[i])):
+			// e.g. GOCACHE=off
+			c.skip = v.name + " is not an absolute path"
+		default:
+			c.src = strings.TrimSpace(values[i])
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// optedOutMounts maps the container paths of the home mounts that opts
+// leaves out (see StartOptions.NoSSH) to the flag doing so.
+func (opts StartOptions) optedOutMounts(containerHome string) map[string]string {
+	optedOut := map[string]string{}
+	if opts.NoSSH {
+		optedOut[containerHome+"/.ssh"] = "--no-ssh"
+	}
+	if opts.NoGitconfig {
+		optedOut[containerHome+"/.gitconfig"] = "--no-gitconfig"
+	}
+	if opts.NoGH {
+		optedOut[containerHome+"/.config/gh"] = "--no-gh"
+	}
+	return optedOut
+}
+
+// scriptsMounts returns the mount of the original workspace's .devcontainer
+// directory over its copy in a worktree, so helper scripts that aren't
+// tracked (and thus missing from the worktree) are still available. It is
+// read-only so the container can't change the original workspace.
+func scriptsMounts(hostWorkspace, containerWorkspace string) []mount {
+	src := filepath.Join(hostWorkspace, ".devcontainer")
+	if !isDir(src) {
+		return nil
+	}
+	return []mount{{src: src, dst: filepath.Join(containerWorkspace, ".devcontainer"), ro: true}}
+}
+
+// mountEntry is a mount of a start, or a candidate it leaves out.
+type mountEntry struct {
+	candidate
+	// note explains the mount in a MountPlan.
+	note string
+	// internal marks the mounts an allowlist (see Config.AllowedMounts)
+	// doesn't apply to: the worktree's VCS metadata, the files made for
+	// the run, and anonymous volumes.
+	internal bool
+	// volume is the -v value of a --volume, passed on as given (with its
+	// options) rather than rendered from mount.
+	volume string
+}
+
+// mountPaths are the paths assembleMounts lays the mounts out by.
+type mountPaths struct {
+	homeDir, containerHome string
+	// hostWorkspace is the original workspace; workspaceDir, mounted at
+	// containerWorkspace, is it or its worktree wt.
+	hostWorkspace, workspaceDir, containerWorkspace string
+
+	wt *worktree
+	// caCert is the absolute path of StartOptions.CACert.
+	caCert string
+}
+
+// mountSet holds the mounts of a start, as assembled by assembleMounts.
+type mountSet struct {
+	entries []mountEntry
+	// env are the docker run -e arguments that go with the mounts.
+	env []string
+	// cleanups undo what was made for the run once it is over.
+	cleanups []func()
+}
+
+// made returns the mounts of r that are made, without the volumes.
+func (r *mountSet) made() []mount {
+	var mounts []mount
+	for _, e := range r.entries {
+		if e.skip == "" && e.volume == "" {
+			mounts = append(mounts, e.mount)
+		}
+	}
+	return mounts
+}
+
+// volumes returns the -v values of the --volume entries of r.
+func (r *mountSet) volumes() []string {
+	var volumes []string
+	for _, e := range r.entries {
+		if e.volume != "" {
+			volumes = append(volumes, e.volume)
+		}
+	}
+	return volumes
+}
+
+// volumeMounts returns the mounts the --volume entries of r make.
+func (r *mountSet) volumeMounts() []mount {
+	var mounts []mount
+	for _, e := range r.entries {
+		if e.volume != "" {
+			mounts = append(mounts, e.mount)
+		}
+	}
+	return mounts
+}
+
+// reviewed returns the mounts of r an allowlist applies to.
+func (r *mountSet) reviewed() []mount {
+	var mounts []mount
+	for _, e := range r.entries {
+		if e.skip == "" && !e.internal {
+			mounts = append(mounts, e.mount)
+		}
+	}
+	return mounts
+}
+
+// close runs r's cleanups.
+func (r *mountSet) close() {
+	for _, f := range r.cleanups {
+		f()
+	}
+}
+
+// assembleMounts lays out the mounts of a start with opts, in order, along
+// with the candidates left out and why. Start and PlanMounts both use it,
+// so what --print-mounts shows is what a start mounts. With plan, nothing
+// is prepared or created: the files made for the run are shown at
+// placeholder paths, and nothing is printed. Otherwise the worktree is
+// prepared for the container and the run's files are created, to be
+// removed by the cleanups.
+func (opts StartOptions) assembleMounts(ctx context.Context, p mountPaths, plan bool) *mountSet {
+	r := &mountSet{}
+	add := func(c candidate, note string) {
+		r.entries = append(r.entries, mountEntry{candidate: c, note: note})
+	}
+	made := func(m mount, note string) {
+		r.entries = append(r.entries, mountEntry{candidate: candidate{mount: m}, note: note, internal: true})
+	}
+	say := func(format string, args ...any) {
+		if !plan {
+			fmt.Fprintf(os.Stderr, "devcontainer: "+format+"\n", args...)
+		}
+	}
+
+	if p.wt != nil {
+		note := "worktree"
+		if p.wt.vcs == "copy" {
+			note = "copy of the workspace"
+		}
+		add(candidate{mount: mount{src: p.workspaceDir, dst: p.containerWorkspace}}, note)
+		for _, m := range scriptsMounts(p.hostWorkspace, p.containerWorkspace) {
+			add(candidate{mount: m}, "")
+		}
+	} else {
+		add(candidate{mount: mount{src: p.workspaceDir, dst: p.containerWorkspace}}, "")
+	}
+
+	optedOut := opts.optedOutMounts(p.containerHome)
+	for _, c := range homeCandidates(p.homeDir, p.containerHome) {
+		if target, ok := strings.CutPrefix(c.skip, danglingSkip); ok {
+			say("warning: skipping mount of %s: it is a symlink to missing %s", c.src, target)
+		}
+		if c.skip == "" && optedOut[c.dst] != "" {
+			c.skip = optedOut[c.dst]
+		}
+		note := ""
+		if c.dst == p.containerHome+"/.claude.json" {
+			// Keep the container from rewriting the host's ~/.claude.json:
+			// mount it read-only, or a throwaway copy made by Start
+			switch {
+			case opts.ReadOnlyClaudeJSON:
+				c.ro = true
+			case opts.CopyClaudeConfig:
+				note = "a copy, discarded on exit"
+			}
+		}
+		add(c, note)
+	}
+
+	// Bazel output base (only if repo uses Bazel)
+	if fileExists(filepath.Join(p.hostWorkspace, "MODULE.bazel")) {
+		cmd := execCommand(ctx, "bazel", "info", "output_base")
+		cmd.Dir = p.hostWorkspace
+		rc := mount{src: filepath.Join(os.TempDir(), "bazel-rc-<random>"), dst: "/etc/bazel.bazelrc", ro: true}
+		out, err := cmd.Output()
+		outputBase := strings.TrimSpace(string(out))
+		skip := ""
+		if err != nil {
+			skip = "bazel info output_base failed"
+		} else if !plan {
+			if f, err := os.CreateTemp("", "bazel-rc-"); err != nil {
+				skip = err.Error()
+			} else {
+				fmt.Fprintf(f, "startup --output_base=%s\n", outputBase)
+				f.Close()
+				rc.src = f.Name()
+				r.cleanups = append(r.cleanups, func() { os.Remove(f.Name()) })
+			}
+		}
+		if skip != "" {
+			add(candidate{mount: mount{dst: rc.dst, ro: true}, skip: skip}, "Bazel output base")
+		} else {
+			add(candidate{mount: mount{src: outputBase, dst: outputBase}}, "")
+			made(rc, "made for the run")
+		}
+	}
+
+	// Docker socket (opt-in)
+	if opts.Docker {
+		c := candidate{mount: mount{src: dockerSock, dst: dockerSock}}
+		if !isSocket(dockerSock) {
+			c.skip = "no Docker socket"
+		}
+		add(c, "")
+	}
+
+	// Cloud credentials (opt-in, unlike the caches above, so credentials
+	// don't leak into every container)
+	credentials := slices.Clone(opts.MountCredentials)
+	if opts.MountAWS {
+		credentials = append(credentials, ".aws")
+	}
+	if opts.MountGcloud {
+		credentials = append(credentials, ".config/gcloud")
+	}
+	for _, c := range credentialCandidates(p.homeDir, p.containerHome, credentials) {
+		if c.skip != "" {
+			say("skipping credential mount %s: %s", c.src, c.skip)
+		}
+		add(c, "")
+	}
+	if opts.MountGitCredentials {
+		found := false
+		for _, c := range gitCredentialCandidates(p.homeDir, p.containerHome) {
+			found = found || c.skip == ""
+			add(c, "--mount-git-credentials")
+		}
+		if !found {
+			say("warning: --mount-git-credentials: found neither ~/.git-credentials nor a git credential cache")
+		}
+	}
+
+	// Go caches (opt-in, since the container then writes to them)
+	if opts.GoCache {
+		for i, c := range goCacheCandidates(ctx, p.containerHome) {
+			if c.skip == "" && !plan {
+				// Created by docker otherwise, as root
+				if err := os.MkdirAll(c.src, 0755); err != nil {
+					c.skip = err.Error()
+				}
+			}
+			if c.skip != "" {
+				say("warning: --go-cache: not mounting %s: %s", goCacheVars[i].name, c.skip)
+			} else {
+				r.env = append(r.env, "-e", goCacheVars[i].name+"="+c.dst)
+			}
+			add(c, "--go-cache")
+		}
+	}
+
+	// SSH agent forwarding
+	if sshSock := os.Getenv("SSH_AUTH_SOCK"); opts.NoSSHAgent {
+		add(candidate{mount: mount{src: sshSock, dst: "/tmp/ssh-agent.sock"}, skip: "--no-ssh-agent"}, "SSH agent")
+	} else if sshSock != "" {
+		add(candidate{mount: mount{src: sshSock, dst: "/tmp/ssh-agent.sock"}}, "SSH agent")
+		r.env = append(r.env, "-e", "SSH_AUTH_SOCK=/tmp/ssh-agent.sock")
+	} else {
+		add(candidate{mount: mount{dst: "/tmp/ssh-agent.sock"}, skip: "SSH_AUTH_SOCK not set"}, "SSH agent")
+	}
+
+	// VS Code editor proxy: let Ctrl-G open a VS Code tab on the host
+	// (not when detached, since the proxy stops when Start returns)
+	if codePath, err := exec.LookPath("code"); err == nil && !opts.Detach {
+		editorDir := filepath.Join(os.TempDir(), "claude-editor-<random>")
+		if !plan {
+			var stop func()
+			if editorDir, stop, err = runEditorProxy(ctx, codePath); err == nil {
+				r.cleanups = append(r.cleanups, stop)
+				say("editor proxy started (code=%s)", codePath)
+			}
+		}
+		if err == nil {
+			made(mount{src: editorDir, dst: "/tmp/claude-editor"}, "VS Code editor proxy, made for the run")
+			r.env = append(r.env, "-e", "VISUAL=vscode-editor")
+		}
+	}
+
+	// Custom CA: node adds it to its roots; for the rest, the image
+	// entrypoint writes caBundle with the system roots plus the CA
+	if p.caCert != "" {
+		add(candidate{mount: mount{p.caCert, containerCACert, true}}, "")
+		r.env = append(r.env,
+			"-e", "DEVCONTAINER_CA_CERT="+containerCACert,
+			"-e", "NODE_EXTRA_CA_CERTS="+containerCACert,
+			"-e", "SSL_CERT_FILE="+caBundle,
+			"-e", "GIT_SSL_CAINFO="+caBundle,
+			"-e", "CARGO_HTTP_CAINFO="+caBundle,
+		)
+	}
+
+	// Worktree VCS backend: mount original repo's VCS dir
+	if p.wt != nil {
+		vcsMounts := p.wt.repoMounts
+		if !plan {
+			vcsMounts = p.wt.vcsMounts
+		}
+		for _, m := range vcsMounts(p.containerWorkspace) {
+			r.entries = append(r.entries, mountEntry{candidate: candidate{mount: m}, note: "VCS metadata", internal: true})
+		}
+	}
+
+	for _, v := range opts.Volumes {
+		v = resolveVolume(v, p.workspaceDir)
+		e := mountEntry{note: "--volume", volume: v}
+		if src, rest, ok := strings.Cut(v, ":"); ok {
+			dst, options, _ := strings.Cut(rest, ":")
+			e.mount = mount{src: src, dst: dst, ro: slices.Contains(strings.Split(options, ","), "ro")}
+		} else {
+			// An anonymous volume, with nothing from the host
+			e.mount, e.internal = mount{dst: v}, true
+		}
+		r.entries = append(r.entries, e)
+	}
+	return r
+}
+
+// mountOptions validates the options that decide what is mounted where,
+// for Start and PlanMounts alike, so a plan fails where the start would.
+// It returns the container user's home and the absolute --ca-cert path
+// ("" without one). gitDir is the resolved --git-dir.
+func (opts StartOptions) mountOptions(gitDir string) (containerHome, caCert string, err error) {
+	if p := opts.MountWorkspaceAt; p != "" && (!filepath.IsAbs(p) || filepath.Clean(p) == "/") {
+		return "", "", fmt.Errorf("invalid --mount-workspace-at %q: must be an absolute path other than /", p)
+	}
+	if opts.CopyOnWrite && (opts.VCS != "" || gitDir != "") {
+		return "", "", fmt.Errorf("cannot combine --copy-on-write with --vcs or --git-dir")
+	}
+	containerHome = devHome
+	if opts.ContainerHome != "" {
+		if !filepath.IsAbs(opts.ContainerHome) || filepath.Clean(opts.ContainerHome) == "/" {
+			return "", "", fmt.Errorf("invalid --container-home %q: must be an absolute path other than /", opts.ContainerHome)
+		}
+		containerHome = filepath.Clean(opts.ContainerHome)
+	}
+	if opts.CACert != "" {
+		if err := validateCACert(opts.CACert); err != nil {
+			return "", "", err
+		}
+		if caCert, err = filepath.Abs(opts.CACert); err != nil {
+			return "", "", err
+		}
+	}
+	return containerHome, caCert, nil
+}
+
+// MountPlan is a mount a start would make, or leave out and why.
+type MountPlan struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly"`
+	// Skipped, if set, is why the mount is left out.
+	Skipped string `json:"skipped,omitempty"`
+	// Note explains a mount that isn't simply the host path: one made for
+	// the run, or one an allowlist (see Config.AllowedMounts) rejects.
+	Note string `json:"note,omitempty"`
+}
+
+// PlanMounts resolves the mounts Start would make with opts, including the
+// candidates it would leave out, without creating a worktree, building the
+// image, or running anything but `bazel info` for a Bazel workspace. The
+// worktree of a would-be run is shown at its path under the temp dir, with
+// a placeholder for a random suffix.
+func PlanMounts(ctx context.Context, opts StartOptions) ([]MountPlan, error) {
+	workspaceDir := opts.Workspace
+	if workspaceDir == "" {
+		var err error
+		if workspaceDir, err = DefaultWorkspace(); err != nil {
+			return nil, err
+		}
+	}
+	workspaceDir, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir(workspaceDir) {
+		return nil, fmt.Errorf("workspace %s does not exist", workspaceDir)
+	}
+	gitDir := opts.GitDir
+	if gitDir != "" {
+		if gitDir, err = filepath.Abs(gitDir); err != nil {
+			return nil, err
+		}
+	}
+	prefix, err := namePrefix(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Profile != "" {
+		if err := cfg.applyProfile(opts.Profile, &opts); err != nil {
+			return nil, err
+		}
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("getting home dir: %w", err)
+	}
+	containerHome, caCert, err := opts.mountOptions(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	hostWorkspace := workspaceDir
+	containerWorkspace := workspaceDir
+	if opts.MountWorkspaceAt != "" {
+		containerWorkspace = filepath.Clean(opts.MountWorkspaceAt)
+	}
+
+	vcsName := opts.VCS
+	if gitDir != "" && vcsName == "" {
+		vcsName = "git"
+	}
+	vcs, err := detectVCS(vcsName, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CopyOnWrite {
+		vcs = "copy"
+	}
+	var wt *worktree
+	if vcs != "" {
+		wt = &worktree{vcs: vcs, original: workspaceDir, gitDir: gitDir, dir: filepath.Join(worktreeParent(vcs, workspaceDir), prefix+"-<random>")}
+		if opts.Name != "" {
+			wt.dir = worktreeOptions{vcs: vcs, workspace: workspaceDir, gitDir: gitDir, prefix: prefix, name: opts.Name, persist: opts.persistWorktree(vcs)}.namedDir()
+		}
+		workspaceDir = wt.dir
+	}
+
+	r := opts.assembleMounts(ctx, mountPaths{
+		homeDir:            homeDir,
+		containerHome:      containerHome,
+		hostWorkspace:      hostWorkspace,
+		workspaceDir:       workspaceDir,
+		containerWorkspace: containerWorkspace,
+		wt:                 wt,
+		caCert:             caCert,
+	}, true)
+
+	var plans []MountPlan
+	for _, e := range r.entries {
+		p := MountPlan{Source: e.src, Target: e.dst, ReadOnly: e.ro, Skipped: e.skip, Note: e.note}
+		if cfg.AllowedMounts != nil && e.skip == "" && !e.internal &&
+			cfg.checkMounts([]mount{e.mount}, homeDir, hostWorkspace, workspaceDir) != nil {
+			p.Note = strings.TrimPrefix(p.Note+"; ", "; ") + "not in allowedMounts: start would fail"
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// FormatMounts writes plans as a table of source, target, mode (ro, rw, or
+// skipped), and notes.
+func FormatMounts(w io.Writer, plans []MountPlan) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTARGET\tMODE\tNOTE")
+	for _, p := range plans {
+		source, mode, note := p.Source, "rw", p.Note
+		if source == "" {
+			source = "-"
+		}
+		if p.ReadOnly {
+			mode = "ro"
+		}
+		if p.Skipped != "" {
+			mode = "skipped"
+			note = strings.TrimSuffix(p.Skipped+"; "+note, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", source, p.Target, mode, note)
+	}
+	return tw.Flush()
+}
+
+// verifyMounts checks the mounts of the container name, as docker inspect
+// reports them, against want, and warns on w about any that didn't
+// materialize or came out writable though asked read-only, which
+// docker doesn't always treat as an error (e.g. one shadowed by a later
+// mount, or a backend that ignores a mode). With verbose, each confirmed
+// mount is listed too.
+func (r Runtime) verifyMounts(ctx context.Context, w io.Writer, name string, want []mount, verbose bool) error {
+	out, err := r.command(ctx, "inspect", "--format", "{{json .Mounts}}", name).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting mounts of %s: %w", name, err)
+	}
+	var got []inspectedMount
+	if err := json.Unmarshal(out, &got); err != nil {
+		return fmt.Errorf("parsing mounts of %s: %w", name, err)
+	}
+	for _, m := range want {
+		i := slices.IndexFunc(got, func(g inspectedMount) bool { return g.Destination == m.dst })
+		switch {
+		case i < 0 && m.src == "":
+			fmt.Fprintf(w, "devcontainer: warning: no volume is mounted at %s in the container\n", m.dst)
+		case i < 0:
+			fmt.Fprintf(w, "devcontainer: warning: %s is not mounted at %s in the container\n", m.src, m.dst)
+		case m.ro && got[i].RW:
+			fmt.Fprintf(w, "devcontainer: warning: %s is mounted read-write at %s, not read-only\n", m.src, m.dst)
+		case verbose:
+			fmt.Fprintf(w, "devcontainer: mounted %s at %s\n", got[i].Source, m.dst)
+		}
+	}
+	return nil
+}
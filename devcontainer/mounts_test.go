@@ -0,0 +1,343 @@
+package devcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMountArg(t *testing.T) {
+	if got := (mount{src: "/a", dst: "/b"}).arg(); got != "/a:/b" {
+		t.Errorf("arg = %q", got)
+	}
+	if got := (mount{src: "/a", dst: "/b", ro: true}).arg(); got != "/a:/b:ro" {
+		t.Errorf("arg = %q", got)
+	}
+}
+
+// madeMounts returns the mounts of the candidates that aren't skipped.
+func madeMounts(candidates []candidate) []mount {
+	var mounts []mount
+	for _, c := range candidates {
+		if c.skip == "" {
+			mounts = append(mounts, c.mount)
+		}
+	}
+	return mounts
+}
+
+func TestHomeCandidates(t *testing.T) {
+	home := t.TempDir()
+	cache := t.TempDir()
+	config := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("XDG_CONFIG_HOME", config)
+	os.WriteFile(filepath.Join(home, ".gitconfig"), nil, 0644)
+	os.Mkdir(filepath.Join(config, "gh"), 0755)
+	os.WriteFile(filepath.Join(home, ".tool-versions"), nil, 0644)
+	os.Mkdir(filepath.Join(config, "mise"), 0755)
+
+	mounts := madeMounts(homeCandidates(home, devHome))
+	for _, want := range []mount{
+		{filepath.Join(cache, "bazelisk"), "/home/dev/.cache/bazelisk", true},
+		{filepath.Join(home, ".claude"), "/home/dev/.claude", false},
+		{filepath.Join(home, ".gitconfig"), "/home/dev/.gitconfig", true},
+		{filepath.Join(config, "gh"), "/home/dev/.config/gh", true},
+		{filepath.Join(home, ".tool-versions"), "/home/dev/.tool-versions", true},
+		{filepath.Join(config, "mise"), "/home/dev/.config/mise", true},
+	} {
+		if !slices.Contains(mounts, want) {
+			t.Errorf("homeCandidates is missing %+v", want)
+		}
+	}
+	// Absent configuration isn't mounted.
+	for _, m := range mounts {
+		switch m.dst {
+		case "/home/dev/.config/jj", "/home/dev/.ssh", "/home/dev/.asdfrc", "/home/dev/.config/rtx":
+			t.Errorf("homeCandidates mounts absent %s", m.src)
+		}
+	}
+}
+
+func TestHomeCandidatesBrokenSymlink(t *testing.T) {
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "unmounted", "go")
+	os.Symlink(target, filepath.Join(home, "go"))
+
+	for _, c := range homeCandidates(home, devHome) {
+		if c.dst == "/home/dev/go" && c.skip != danglingSkip+target {
+			t.Errorf("broken symlink %s skipped for %q", c.src, c.skip)
+		}
+	}
+}
+
+func TestStartBrokenSymlinkWarning(t *testing.T) {
+	f := newDetachedStart(t)
+	home := os.Getenv("HOME")
+	target := filepath.Join(t.TempDir(), "unmounted", "go")
+	os.Symlink(target, filepath.Join(home, "go"))
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(stderr.Name())
+	if want := "devcontainer: warning: skipping mount of " + filepath.Join(home, "go") + ": it is a symlink to missing " + target + "\n"; strings.Count(string(data), want) != 1 {
+		t.Errorf("stderr = %q, want %q once", data, want)
+	}
+	if run := f.find("run"); slices.ContainsFunc(run, func(arg string) bool { return strings.HasSuffix(arg, ":/home/dev/go:ro") }) {
+		t.Errorf("broken symlink mounted: %q", run)
+	}
+}
+
+func TestHomeCandidatesRelativeXDG(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", "relative/cache")
+
+	mounts := madeMounts(homeCandidates(home, devHome))
+	want := mount{filepath.Join(home, ".cache", "pnpm"), "/home/dev/.cache/pnpm", true}
+	if !slices.Contains(mounts, want) {
+		t.Errorf("relative XDG_CACHE_HOME was not ignored: %+v", mounts)
+	}
+}
+
+func TestCredentialCandidates(t *testing.T) {
+	home := t.TempDir()
+	os.Mkdir(filepath.Join(home, ".aws"), 0755)
+	outside := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(outside, nil, 0600)
+
+	got := madeMounts(credentialCandidates(home, devHome, []string{".aws", outside, ".azure"}))
+	want := []mount{
+		{filepath.Join(home, ".aws"), "/home/dev/.aws", true},
+		{outside, outside, true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("credentialCandidates = %+v, want %+v", got, want)
+	}
+}
+
+func TestScriptsMounts(t *testing.T) {
+	workspace := t.TempDir()
+	if got := scriptsMounts(workspace, "/src"); got != nil {
+		t.Errorf("scriptsMounts without .devcontainer = %+v", got)
+	}
+	os.Mkdir(filepath.Join(workspace, ".devcontainer"), 0755)
+	want := []mount{{filepath.Join(workspace, ".devcontainer"), "/src/.devcontainer", true}}
+	if got := scriptsMounts(workspace, "/src"); !slices.Equal(got, want) {
+		t.Errorf("scriptsMounts = %+v, want %+v", got, want)
+	}
+}
+
+func TestGitCredentialCandidates(t *testing.T) {
+	home := t.TempDir()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	os.WriteFile(filepath.Join(home, ".git-credentials"), nil, 0600)
+	os.MkdirAll(filepath.Join(cache, "git", "credential"), 0700)
+
+	got := madeMounts(gitCredentialCandidates(home, devHome))
+	want := []mount{
+		{filepath.Join(home, ".git-credentials"), "/home/dev/.git-credentials", true},
+		{filepath.Join(cache, "git", "credential"), "/home/dev/.cache/git/credential", true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("gitCredentialCandidates = %+v, want %+v", got, want)
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if got := madeMounts(gitCredentialCandidates(t.TempDir(), devHome)); len(got) != 0 {
+		t.Errorf("gitCredentialCandidates without credentials = %+v", got)
+	}
+}
+
+func TestPlanMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("PATH", t.TempDir())
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	workspace := t.TempDir()
+	os.Mkdir(filepath.Join(workspace, ".git"), 0755)
+	writeConfig(t, workspace, `{"allowedMounts": ["~/.claude"]}`)
+
+	plans, err := PlanMounts(context.Background(), StartOptions{Workspace: workspace, Name: "audit", NoSSH: true, MountAWS: true, ReadOnlyClaudeJSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTarget := map[string]MountPlan{}
+	for _, p := range plans {
+		byTarget[p.Target] = p
+	}
+	if p := byTarget[workspace]; p.Source != filepath.Join(os.TempDir(), "devcontainer-audit") {
+		t.Errorf("workspace mount = %+v, want the would-be worktree", p)
+	}
+	if p := byTarget["/.devcontainer-git"]; p.Source != filepath.Join(workspace, ".git") {
+		t.Errorf("git mount = %+v", p)
+	}
+	for target, skipped := range map[string]string{
+		"/home/dev/.ssh":       "--no-ssh",
+		"/home/dev/.gitconfig": "not present",
+		"/home/dev/.aws":       "not found",
+		"/tmp/ssh-agent.sock":  "SSH_AUTH_SOCK not set",
+	} {
+		if p := byTarget[target]; p.Skipped != skipped {
+			t.Errorf("%s skipped = %q, want %q", target, p.Skipped, skipped)
+		}
+	}
+	if p := byTarget["/home/dev/.claude.json"]; !p.ReadOnly || !strings.Contains(p.Note, "not in allowedMounts") {
+		t.Errorf("~/.claude.json = %+v, want read-only and rejected", p)
+	}
+	if p := byTarget["/home/dev/.claude"]; p.Note != "" {
+		t.Errorf("allowed ~/.claude = %+v", p)
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), "devcontainer-audit")); err == nil {
+		t.Error("PlanMounts created the worktree")
+	}
+}
+
+func TestPlanMountsMatchesStart(t *testing.T) {
+	f := newDetachedStart(t)
+	home := os.Getenv("HOME")
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	os.Mkdir(filepath.Join(home, ".aws"), 0700)
+	workspace := t.TempDir()
+	opts := StartOptions{Workspace: workspace, Detach: true, MountAWS: true, NoGH: true, ReadOnlyClaudeJSON: true, Volumes: []string{"data:/data:ro", "/opt/x:/x"}}
+
+	plans, err := PlanMounts(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range plans {
+		if p.Skipped == "" {
+			want = append(want, mount{p.Source, p.Target, p.ReadOnly}.arg())
+		}
+	}
+	if _, err := Start(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	run := f.find("run")
+	var got []string
+	for i, a := range run {
+		if a == "-v" {
+			got = append(got, run[i+1])
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("docker run mounts:\n  %s\nwant, as planned:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+func TestPlanMountsRejectsWhatStartDoes(t *testing.T) {
+	newDetachedStart(t)
+	badCert := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(badCert, []byte("not a certificate\n"), 0644)
+	for _, opts := range []StartOptions{
+		{ContainerHome: "home/dev"},
+		{CACert: badCert},
+		{CopyOnWrite: true, VCS: "git"},
+		{MountWorkspaceAt: "/"},
+	} {
+		opts.Workspace, opts.Detach = t.TempDir(), true
+		_, planErr := PlanMounts(context.Background(), opts)
+		_, startErr := Start(context.Background(), opts)
+		if planErr == nil || startErr == nil || planErr.Error() != startErr.Error() {
+			t.Errorf("%+v: PlanMounts = %v, Start = %v, want the same error", opts, planErr, startErr)
+		}
+	}
+}
+
+func TestVerifyMounts(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) {
+		return `[{"Type":"bind","Source":"/home/u/.ssh","Destination":"/home/dev/.ssh","RW":true},` +
+			`{"Type":"bind","Source":"/home/u/go","Destination":"/home/dev/go","RW":true}]`, 0
+	})
+	want := []mount{
+		{src: "/home/u/go", dst: "/home/dev/go"},
+		{src: "/home/u/.ssh", dst: "/home/dev/.ssh", ro: true},
+		{src: "/home/u/.gitconfig", dst: "/home/dev/.gitconfig", ro: true},
+	}
+
+	var b strings.Builder
+	if err := (Runtime{}).verifyMounts(context.Background(), &b, "devcontainer-x", want, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "devcontainer: warning: /home/u/.ssh is mounted read-write at /home/dev/.ssh, not read-only\n"+
+		"devcontainer: warning: /home/u/.gitconfig is not mounted at /home/dev/.gitconfig in the container\n"; got != want {
+		t.Errorf("report = %q, want %q", got, want)
+	}
+
+	b.Reset()
+	if err := (Runtime{}).verifyMounts(context.Background(), &b, "devcontainer-x", want[:1], true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "devcontainer: mounted /home/u/go at /home/dev/go\n" {
+		t.Errorf("verbose report = %q", b.String())
+	}
+}
+
+func TestStartReportsMountsAfterSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+	var during string
+	newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "{{json .Mounts}}"):
+			return "[]", 0
+		case slices.Contains(call, "run"):
+			data, _ := os.ReadFile(stderr.Name())
+			during = string(data)
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, Volumes: []string{"/opt/x:/x", "/cache"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(stderr.Name())
+	for _, want := range []string{
+		"devcontainer: warning: /opt/x is not mounted at /x in the container\n",
+		"devcontainer: warning: no volume is mounted at /cache in the container\n",
+	} {
+		if !strings.Contains(string(data), want) || strings.Contains(during, want) {
+			t.Errorf("stderr = %q, want it to contain %q once the session is over", data, want)
+		}
+	}
+}
+
+func TestGoCacheCandidates(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) { return "/home/u/go/pkg/mod\noff\n", 0 })
+	got := goCacheCandidates(context.Background(), devHome)
+	want := []candidate{
+		{mount: mount{src: "/home/u/go/pkg/mod", dst: devHome + "/.cache/go-mod"}},
+		{mount: mount{dst: devHome + "/.cache/go-build"}, skip: "GOCACHE is not an absolute path"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("goCacheCandidates = %+v, want %+v", got, want)
+	}
+
+	newFakeExec(t, func(call []string) (string, int) { return "", 1 })
+	for _, c := range goCacheCandidates(context.Background(), devHome) {
+		if c.skip == "" {
+			t.Errorf("mounted %+v though go env failed", c)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package devcontainer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// claudeSession is a Claude Code session transcript found on the host.
+type claudeSession struct {
+	ID    string
+	Title string
+}
+
+// claudeProjectDir returns the directory under ~/.claude/projects where
+// Claude Code keeps session transcripts for workspace. Claude derives the
+// directory name by replacing every non-alphanumeric character of the
+// absolute path with '-'.
+func claudeProjectDir(homeDir, workspace string) string {
+	escaped := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, workspace)
+	return filepath.Join(homeDir, ".claude", "projects", escaped)
+}
+
+// listClaudeSessions returns the sessions recorded in projectDir, titled by
+// their custom title or, failing that, their latest summary.
+func listClaudeSessions(projectDir string) ([]claudeSession, error) {
+	entries, err := os.ReadDir(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []claudeSession
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(projectDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var summary, customTitle string
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 64*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			// Skip decoding the (large) message entries
+			if !bytes.Contains(line, []byte(`"summary"`)) && !bytes.Contains(line, []byte(`"customTitle"`)) {
+				continue
+			}
+			var entry struct {
+				Summary     string `json:"summary"`
+				CustomTitle string `json:"customTitle"`
+			}
+			if json.Unmarshal(line, &entry) != nil {
+				continue
+			}
+			if entry.Summary != "" {
+				summary = entry.Summary
+			}
+			if entry.CustomTitle != "" {
+				customTitle = entry.CustomTitle
+			}
+		}
+		f.Close()
+		title := customTitle
+		if title == "" {
+			title = summary
+		}
+		sessions = append(sessions, claudeSession{ID: id, Title: title})
+	}
+	return sessions, nil
+}
+
+// resolveResume maps a --resume value to a session ID. Exact session IDs are
+// passed through; otherwise the value is matched case-insensitively against
+// session titles. Values matching nothing are passed through unchanged so
+// claude can still interpret them. Multiple matches prompt for a choice.
+func resolveResume(resume, projectDir string) (string, error) {
+	sessions, err := listClaudeSessions(projectDir)
+	if err != nil {
+		return resume, nil
+	}
+
+	var matches []claudeSession
+	needle := strings.ToLower(resume)
+	for _, s := range sessions {
+		if s.ID == resume {
+			return resume, nil
+		}
+		if s.Title != "" && strings.Contains(strings.ToLower(s.Title), needle) {
+			matches = append(matches, s)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return resume, nil
+	case 1:
+		fmt.Fprintf(os.Stderr, "devcontainer: resuming session %s (%s)\n", matches[0].ID, matches[0].Title)
+		return matches[0].ID, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("%d sessions match %q; pass a session ID or run interactively", len(matches), resume)
+	}
+
+	items := make([]string, len(matches))
+	for i, m := range matches {
+		items[i] = m.Title + " (" + m.ID + ")"
+	}
+	prompt := promptui.Select{
+		Label:  "Select a session to resume",
+		Items:  items,
+		Stdout: os.Stderr,
+	}
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("selection: %w", err)
+	}
+	return matches[idx].ID, nil
+}
+
+func trustWorkspace(claudeJSONPath string, workspacePath string) error {
+	if !fileExists(claudeJSONPath) {
+		return nil
+	}
+
+	data, err := os.ReadFile(claudeJSONPath)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	projects, ok := config["projects"].(map[string]interface{})
+	if !ok {
+		projects = make(map[string]interface{})
+		config["projects"] = projects
+	}
+
+	workspace, ok := projects[workspacePath].(map[string]interface{})
+	if !ok {
+		workspace = make(map[string]interface{})
+		projects[workspacePath] = workspace
+	}
+
+	workspace["hasTrustDialogAccepted"] = true
+	workspace["hasCompletedProjectOnboarding"] = true
+
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(claudeJSONPath, append(out, '\n'), 0644)
+}
@@ -0,0 +1,1709 @@
+package devcontainer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// StartOptions configures Start. The zero value runs the image's default
+// command in a fresh worktree of DefaultWorkspace.
+type StartOptions struct {
+	Runtime
+
+	// Workspace is the repository to start from (default: DefaultWorkspace).
+	Workspace string
+	// Name names the worktree and container (default: random suffix).
+	Name string
+	// NameFromBranch, without Name, starts the random suffix with the git
+	// branch checked out in Workspace (sanitized), e.g.
+	// devcontainer-fix-login-1234567, so containers are easier to tell
+	// apart. On a detached HEAD the suffix is just random.
+	NameFromBranch bool
+	// VCS overrides VCS detection: "git" or "jj" (default:
+	// $DEVCONTAINER_VCS, then auto-detect from .jj/ or .git/).
+	VCS string
+	// GitDir is the git directory of Workspace when it isn't
+	// Workspace/.git, for unusual repository layouts. It implies git.
+	GitDir string
+	// Force, with Name, prunes stale worktrees and deletes a leftover
+	// branch before creating the worktree.
+	Force bool
+	// Persist, with Name, keeps the worktree after the container exits and
+	// marks it persistent: later starts with the same Name reuse it as it
+	// is, with or without Persist, instead of recreating it. Force
+	// recreates it.
+	Persist bool
+	// KeepContainer keeps the container once its command exits instead of
+	// removing it, along with the worktree it mounts, so a crashed session
+	// can be restarted and investigated with exec --start. It can't be
+	// combined with Freeze, which removes the container it commits.
+	KeepContainer bool
+	// KeepWorktree keeps the worktree after the container exits instead of
+	// removing it; with git, its branch is then kept too.
+	KeepWorktree bool
+	// KeepOnFailure keeps the worktree like KeepWorktree, but only if the
+	// container's command fails or times out, so its state can be
+	// investigated.
+	KeepOnFailure bool
+	// BranchPolicy controls whether the git branch of the worktree is
+	// deleted afterwards (default: it is kept).
+	BranchPolicy BranchPolicy
+	// IncludeDirty starts the worktree with the workspace's uncommitted
+	// changes, including untracked files, instead of the last commit.
+	IncludeDirty bool
+	// Track, as <remote>/<branch>, fetches that remote branch and starts
+	// the worktree from it instead of the last commit, e.g. to review a
+	// PR. With git the worktree's branch tracks it; with jj the workspace
+	// is based on the remote bookmark.
+	Track string
+	// CopyOnWrite isolates the container in a copy of the workspace
+	// instead of a VCS worktree, e.g. for a repository without a VCS or one
+	// too large for worktrees. The copy shares blocks with the original
+	// where the filesystem supports reflinks (btrfs, XFS, APFS) and is a
+	// full copy elsewhere. It includes uncommitted and ignored files, and
+	// is removed on exit like a worktree.
+	CopyOnWrite bool
+	// Yes skips confirmation prompts (also $DEVCONTAINER_YES).
+	Yes bool
+	// NoNewContainer attaches a shell to a devcontainer already running for
+	// the workspace (the one named Name, if set) instead of starting
+	// another; the remaining options only apply when none is running.
+	NoNewContainer bool
+	// Verbose logs extra detail about the container setup to stderr.
+	Verbose bool
+	// Quiet hides the image build output behind a spinner; see
+	// BuildOptions.Quiet.
+	Quiet bool
+
+	// Args is the command to run in the container.
+	Args []string
+	// Resume resumes the Claude session with this ID, or whose title
+	// contains it. Can't be combined with Args.
+	Resume string
+	// ResumeLatest resumes the most recent Claude session.
+	ResumeLatest bool
+	// Shell runs a login shell instead of claude, e.g. to reopen the
+	// branch of a named worktree (see Name) and look around before
+	// resuming claude there. With Name, the worktree is made persistent as
+	// with Persist, so what the shell leaves behind is still there for the
+	// next start. Can't be combined with Args or a resume.
+	Shell bool
+	// PermissionMode sets how claude asks for tool permissions when this
+	// package starts it, i.e. on a resume or with the image's default
+	// command: "skip" (the default) passes --dangerously-skip-permissions,
+	// "prompt" passes nothing so claude's own settings apply, and "default"
+	// forces claude's default mode, prompting for each tool.
+	PermissionMode string
+	// Model, if set, is passed to claude as --model when this package
+	// starts it (see PermissionMode), e.g. to pin a container to a model
+	// for a task. Can't be combined with Args, Shell, or a default command.
+	Model string
+	// ResumeArgs are extra claude arguments for a resume (e.g. "--model
+	// opus"), split into words like a shell would.
+	ResumeArgs string
+	// DefaultCmd is run when neither Args nor a resume is given (default:
+	// $DEVCONTAINER_DEFAULT_CMD, then the image's CMD). It is split into
+	// words like a shell would.
+	DefaultCmd string
+	// NoStdin runs the container without attaching stdin; output is still
+	// streamed until the command exits.
+	NoStdin bool
+	// Detach starts the container in the background and returns once it
+	// is running, with its network details in the Result. The worktree is
+	// kept for the container to use.
+	Detach bool
+	// NoInterruptKill forwards every SIGINT or SIGTERM to the container.
+	// Otherwise only the first is forwarded, letting the command shut down
+	// cleanly, and a second one kills the container (docker kill).
+	NoInterruptKill bool
+	// Timeout, if set, limits how long the container's command may run,
+	// e.g. so a hung session can't block CI: the container is then killed,
+	// cleaned up as usual, and Result.ExitCode is TimeoutExitCode. It can't
+	// be combined with Detach.
+	Timeout time.Duration
+	// Wait, with Detach, returns only once the container is ready: running
+	// and, if it has a health check (see HealthCmd), healthy. WaitTimeout
+	// bounds the wait; 0 waits as long as ctx allows.
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// PullPolicy controls pulling the base image: "always", "missing"
+	// (default), or "never". With Registry, it applies to the devcontainer
+	// image itself.
+	PullPolicy string
+	// Registry, if set, is where the devcontainer image is pulled from
+	// instead of being built, e.g. ghcr.io/me for a remote docker context
+	// that can't build it: the image is run as Registry/<image name>, with
+	// the name it would be built under.
+	Registry string
+	// BuildArgs are extra KEY=VALUE arguments for the image build. The
+	// image is tagged per argument set; see imageTag.
+	BuildArgs []string
+	// Userns is the user namespace mode of the container (docker run
+	// --userns), e.g. "host" to opt out of the daemon's userns-remap so
+	// the build-time UID/GID own the bind mounts as on the host. It
+	// replaces the --userns=keep-id used for podman.
+	Userns string
+	// ContainerHome is the home directory of the container user, where the
+	// toolchain, cache, and configuration mounts land (default
+	// /home/dev). Another home is passed to the build as USER_HOME, e.g.
+	// for a custom base image whose user lives elsewhere.
+	ContainerHome string
+	// RemoveImage removes the image after the container exits if it is a
+	// variant tagged for BuildArgs. The shared default image is always kept,
+	// and so is every image when detached or pulled from Registry.
+	RemoveImage bool
+	// CIDFile, if set, is a file docker writes the container ID to on
+	// start. Its directory must exist and the file must not.
+	CIDFile string
+	// Events, if set, receives the container's lifecycle events (create,
+	// start, die) from docker events as JSON Lines while it runs: "-" for
+	// stderr or a file to append to. It can't be combined with Detach.
+	Events string
+
+	// Freeze, if set, commits the container's final state to an image with
+	// this reference once the command exits, for inspecting it later. It
+	// can't be combined with Detach.
+	Freeze string
+	// CopyOut copies files out of the container once the command exits,
+	// before it and the worktree are removed, each as
+	// container-path:host-path. A relative container path is relative to
+	// the workspace in the container, a relative host path to the current
+	// directory. It can't be combined with Detach.
+	CopyOut []string
+
+	// BuildContext, if set, is a directory (relative to the workspace) used
+	// as the docker build context, with its .devcontainer/Dockerfile built
+	// instead of the embedded one, so that Dockerfile can COPY repo files.
+	// The image is tagged as a variant, like one for BuildArgs.
+	BuildContext string
+
+	// Prefix starts the names of the container, git branch, and jj
+	// workspace, followed by "-" and Name or a random suffix (default
+	// DefaultPrefix). Exec must use the same prefix to find the container.
+	Prefix string
+	// WorkspaceStyle is how the workspace is identified in the container's
+	// claude-devcontainer.workspace label; see WorkspaceStyle.
+	WorkspaceStyle WorkspaceStyle
+
+	// Profile names a profile in the workspace's ConfigFile whose ports,
+	// volumes, tmpfs mounts, labels, build args and credential mounts are
+	// merged ahead of those set here.
+	Profile string
+
+	// MountWorkspaceAt is the absolute path the workspace is mounted at in
+	// the container, which is also the working directory and the path
+	// trusted in ~/.claude.json (default: the workspace's host path).
+	MountWorkspaceAt string
+
+	// Docker mounts the host Docker socket into the container.
+	Docker bool
+	// Ports are published as hostPort:containerPort.
+	Ports []string
+	// PublishAll publishes every port the image EXPOSEs on a random host
+	// port (docker run -P). The assigned ports are in the Result when
+	// detached, and printed once the container is up otherwise.
+	PublishAll bool
+	// Volumes are additional host:container[:options] mounts. Relative host
+	// paths are resolved against the workspace.
+	Volumes []string
+	// Tmpfs mounts tmpfs filesystems (path[:options]).
+	Tmpfs []string
+	// Devices are host devices passed through to the container
+	// (host[:container[:permissions]]). The container user is added to
+	// each device's group so it can open them despite --cap-drop ALL.
+	Devices []string
+	// Labels are extra key=value labels for the container, e.g. to group
+	// devcontainers by project or owner (see ListOptions.LabelFilters).
+	// Keys under claude-devcontainer.* are reserved.
+	Labels []string
+	// LabelFiles are files of key=value lines (blank lines and # comments
+	// ignored) added to Labels, ahead of them so Labels take precedence.
+	LabelFiles []string
+	// ReadOnlyClaudeJSON mounts ~/.claude.json read-only so sessions
+	// can't change the host's Claude config (claude then can't save
+	// settings or project state either). CopyClaudeConfig instead mounts a
+	// copy the container may change, discarded when it exits; in between
+	// the two, the session runs normally but nothing reaches the host.
+	ReadOnlyClaudeJSON bool
+	CopyClaudeConfig   bool
+	// NoSSH, NoGitconfig, and NoGH leave out the mounts of ~/.ssh,
+	// ~/.gitconfig, and the gh config otherwise made when they exist, so no
+	// host SSH keys, git identity, or GitHub token reach the container.
+	NoSSH       bool
+	NoGitconfig bool
+	NoGH        bool
+	// NoSSHAgent leaves out the SSH agent socket otherwise forwarded when
+	// SSH_AUTH_SOCK is set, so the container can't sign with host keys.
+	NoSSHAgent bool
+	// MountAWS and MountGcloud mount ~/.aws and ~/.config/gcloud read-only.
+	MountAWS    bool
+	MountGcloud bool
+	// MountGitCredentials mounts git's credential store and credential
+	// cache read-only; see gitCredentialMounts.
+	MountGitCredentials bool
+	// GoCache mounts the host's Go module and build caches, wherever go
+	// env places them, writable and points GOMODCACHE and GOCACHE in the
+	// container at them, so builds there share and fill the host's caches.
+	GoCache bool
+	// MountCredentials are further credential paths to mount read-only;
+	// see credentialMounts.
+	MountCredentials []string
+
+	// CACert is a PEM CA bundle to trust in the container, e.g. for TLS
+	// interception by a corporate proxy.
+	CACert string
+
+	// EnvPassthroughClaude forwards the host's ANTHROPIC_* and CLAUDE_*
+	// environment variables into the container.
+	EnvPassthroughClaude bool
+	// Locale sets LANG and LC_ALL in the container, e.g. "en_US.UTF-8".
+	// Empty forwards the host's LANG if set; "none" leaves the image's
+	// default.
+	Locale string
+	// Proxy forwards the host's HTTP_PROXY, HTTPS_PROXY and NO_PROXY (in
+	// either case) into the container.
+	Proxy bool
+
+	// CapDrop lists the capabilities to drop; nil drops ALL.
+	CapDrop []string
+	// CapAdd lists capabilities to add back.
+	CapAdd []string
+	// Memory and MemorySwap limit the container's memory (docker sizes
+	// such as "8g"; MemorySwap may be "-1").
+	Memory     string
+	MemorySwap string
+	// MemoryReservation is a soft memory limit: the container may exceed
+	// it while the host has memory to spare, but is reclaimed down to it
+	// under pressure.
+	MemoryReservation string
+	// PidsLimit caps the number of processes; 0 means no limit.
+	PidsLimit int
+	// ShmSize is the size of /dev/shm (docker's default is 64m, too small
+	// for headless Chrome and some test runners).
+	ShmSize string
+
+	// StopSignal is the signal docker sends to stop the container (default
+	// SIGTERM), e.g. SIGINT for programs that flush state on interrupt.
+	StopSignal string
+	// StopTimeout is how many seconds docker waits after StopSignal before
+	// killing the container; 0 uses docker's default of 10.
+	StopTimeout int
+
+	// HealthCmd is a readiness check run in the container (through the
+	// shell), overriding any HEALTHCHECK of the image, so docker reports
+	// the container healthy or unhealthy. HealthInterval (a duration such
+	// as "30s") and HealthRetries tune it and require HealthCmd; empty and
+	// 0 use docker's defaults.
+	HealthCmd      string
+	HealthInterval string
+	HealthRetries  int
+}
+
+// TimeoutExitCode is the Result.ExitCode of a run stopped by
+// StartOptions.Timeout, as with timeout(1).
+const TimeoutExitCode = 124
+
+// Result describes a finished Start.
+type Result struct {
+	// ContainerName is the name the container ran under.
+	ContainerName string `json:"containerName"`
+	// ContainerID is the full container ID (detached only).
+	ContainerID string `json:"containerID,omitempty"`
+	// WorktreeDir is the host worktree the container worked in; empty for a
+	// workspace without a VCS.
+	WorktreeDir string `json:"worktreeDir,omitempty"`
+	// Branch is the git branch checked out in the worktree (git only).
+	Branch string `json:"branch,omitempty"`
+	// ExitCode is the exit status of the container's command. It is always
+	// 0 when detached.
+	ExitCode int `json:"exitCode"`
+
+	// IPAddress is the container's IP address: on the default bridge, or
+	// else on the first of its networks (detached only).
+	IPAddress string `json:"ipAddress,omitempty"`
+	// Networks maps each network the container joined to its IP address
+	// there (detached only).
+	Networks map[string]string `json:"networks,omitempty"`
+	// Ports lists the published ports with the host ports docker assigned
+	// (detached only).
+	Ports []PortBinding `json:"ports,omitempty"`
+
+	// FrozenImage is the image ID the container was committed to with
+	// StartOptions.Freeze.
+	FrozenImage string `json:"frozenImage,omitempty"`
+}
+
+// PortBinding is a container port published on the host.
+type PortBinding struct {
+	// ContainerPort is the port and protocol in the container, e.g.
+	// "8080/tcp".
+	ContainerPort string `json:"containerPort"`
+	HostIP        string `json:"hostIP"`
+	HostPort      string `json:"hostPort"`
+}
+
+// containerCACert is where StartOptions.CACert is mounted, and caBundle the
+// combined bundle the image entrypoint writes from it.
+const (
+	containerCACert = "/usr/local/share/ca-certificates/devcontainer-ca.crt"
+	caBundle        = "/tmp/devcontainer-ca-bundle.pem"
+)
+
+// Start builds the image, creates an isolated worktree, and runs the
+// container attached to the terminal until its command exits. The worktree
+// is removed afterwards. A non-zero exit of the command is reported in
+// Result.ExitCode rather than as an error. SIGINT and SIGTERM are
+// forwarded to the container meanwhile, so cancelling ctx doesn't stop an
+// attached container; see Timeout. With Detach, Start returns as soon as
+// the container is running instead.
+func Start(ctx context.Context, opts StartOptions) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	resuming := opts.ResumeLatest || opts.Resume != ""
+	if resuming && len(opts.Args) > 0 {
+		return nil, fmt.Errorf("cannot combine --resume with extra command arguments")
+	}
+	if opts.ReadOnlyClaudeJSON && opts.CopyClaudeConfig {
+		return nil, fmt.Errorf("cannot combine --read-only-claude-json with --copy-claude-config")
+	}
+	if opts.Shell && (resuming || len(opts.Args) > 0) {
+		return nil, fmt.Errorf("cannot combine --shell with --resume or a command")
+	}
+	resumeArgs, err := splitArgs(opts.ResumeArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --resume-args %q: %w", opts.ResumeArgs, err)
+	}
+	if len(resumeArgs) > 0 && !resuming {
+		return nil, fmt.Errorf("--resume-args requires --resume")
+	}
+
+	permissionArgs, err := claudePermissionArgs(opts.PermissionMode)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Model != "" {
+		if strings.ContainsAny(opts.Model, " \t\n") {
+			return nil, fmt.Errorf("invalid --model %q: must not contain whitespace", opts.Model)
+		}
+		if opts.Shell || len(opts.Args) > 0 {
+			return nil, fmt.Errorf("cannot combine --model with --shell or a command; pass --model to claude in the command instead")
+		}
+		// claude is started explicitly, so the model goes with the
+		// permission flags wherever those go
+		permissionArgs = append(permissionArgs, "--model", opts.Model)
+	}
+
+	workspaceDir := opts.Workspace
+	if workspaceDir == "" {
+		workspaceDir, err = DefaultWorkspace()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if workspaceDir, err = filepath.Abs(workspaceDir); err != nil {
+		return nil, err
+	}
+	if !isDir(workspaceDir) {
+		return nil, fmt.Errorf("workspace %s does not exist", workspaceDir)
+	}
+	gitDir := opts.GitDir
+	if gitDir != "" {
+		if opts.VCS == "jj" {
+			return nil, fmt.Errorf("--git-dir requires git, not jj")
+		}
+		if gitDir, err = filepath.Abs(gitDir); err != nil {
+			return nil, err
+		}
+		if !fileExists(filepath.Join(gitDir, "HEAD")) {
+			return nil, fmt.Errorf("--git-dir %s is not a git directory", opts.GitDir)
+		}
+	}
+
+	prefix, err := namePrefix(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	wsLabel, err := workspaceLabel(ctx, opts.WorkspaceStyle, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := LoadConfig(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Profile != "" {
+		if err := cfg.applyProfile(opts.Profile, &opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Ports, err = normalizePorts(opts.Ports); err != nil {
+		return nil, err
+	}
+
+	// Validate resource limits
+	if opts.Memory != "" && !isByteSize(opts.Memory) {
+		return nil, fmt.Errorf("invalid --memory %q: expected a size such as 512m or 8g", opts.Memory)
+	}
+	if opts.MemorySwap != "" {
+		if opts.MemorySwap != "-1" && !isByteSize(opts.MemorySwap) {
+			return nil, fmt.Errorf("invalid --memory-swap %q: expected a size such as 12g, or -1", opts.MemorySwap)
+		}
+		if opts.Memory == "" {
+			return nil, fmt.Errorf("--memory-swap requires --memory")
+		}
+	}
+	if opts.MemoryReservation != "" && !isByteSize(opts.MemoryReservation) {
+		return nil, fmt.Errorf("invalid --memory-reservation %q: expected a size such as 4g", opts.MemoryReservation)
+	}
+	if opts.ShmSize != "" && !isByteSize(opts.ShmSize) {
+		return nil, fmt.Errorf("invalid --shm-size %q: expected a size such as 2g", opts.ShmSize)
+	}
+	if opts.PidsLimit < 0 {
+		return nil, fmt.Errorf("invalid --pids-limit %d: must not be negative", opts.PidsLimit)
+	}
+
+	// Validate stop handling
+	stopSignal := opts.StopSignal
+	if stopSignal != "" {
+		if stopSignal, err = normalizeStopSignal(stopSignal); err != nil {
+			return nil, err
+		}
+	}
+	if opts.StopTimeout < 0 {
+		return nil, fmt.Errorf("invalid --stop-timeout %d: must not be negative", opts.StopTimeout)
+	}
+
+	if strings.ContainsAny(opts.Userns, " \t\n") {
+		return nil, fmt.Errorf("invalid --userns %q: must not contain whitespace", opts.Userns)
+	}
+	if opts.HealthCmd == "" && (opts.HealthInterval != "" || opts.HealthRetries != 0) {
+		return nil, fmt.Errorf("--health-interval and --health-retries require --health-cmd")
+	}
+	if opts.HealthInterval != "" {
+		if d, err := time.ParseDuration(opts.HealthInterval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid --health-interval %q: expected a positive duration such as 30s", opts.HealthInterval)
+		}
+	}
+	if opts.HealthRetries < 0 {
+		return nil, fmt.Errorf("invalid --health-retries %d: must not be negative", opts.HealthRetries)
+	}
+
+	for _, t := range opts.Tmpfs {
+		if err := validateTmpfs(t); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range opts.Devices {
+		if err := validateDevice(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.ContainsAny(opts.Locale, "= \t") {
+		return nil, fmt.Errorf("invalid --locale %q: expected a locale name such as en_US.UTF-8", opts.Locale)
+	}
+
+	containerHome, caCert, err := opts.mountOptions(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CIDFile != "" {
+		if fileExists(opts.CIDFile) {
+			return nil, fmt.Errorf("--cidfile %s already exists: docker won't overwrite it, so remove it first", opts.CIDFile)
+		}
+		if dir := filepath.Dir(opts.CIDFile); !isDir(dir) {
+			return nil, fmt.Errorf("--cidfile %s: directory %s does not exist", opts.CIDFile, dir)
+		}
+	}
+
+	if opts.Wait && !opts.Detach {
+		return nil, fmt.Errorf("--wait requires --detach")
+	}
+	if opts.Timeout < 0 {
+		return nil, fmt.Errorf("invalid --timeout %s: must not be negative", opts.Timeout)
+	}
+	if opts.KeepOnFailure && opts.Detach {
+		return nil, fmt.Errorf("cannot combine --keep-on-failure with --detach, whose worktree is kept anyway")
+	}
+	if opts.Timeout > 0 && opts.Detach {
+		return nil, fmt.Errorf("cannot combine --timeout with --detach")
+	}
+	if opts.WaitTimeout < 0 {
+		return nil, fmt.Errorf("invalid --wait-timeout %s: must not be negative", opts.WaitTimeout)
+	}
+	if opts.Freeze != "" && opts.Detach {
+		return nil, fmt.Errorf("cannot combine --freeze with --detach")
+	}
+	if opts.KeepContainer && opts.Freeze != "" {
+		return nil, fmt.Errorf("cannot combine --keep-container with --freeze")
+	}
+	if len(opts.CopyOut) > 0 && opts.Detach {
+		return nil, fmt.Errorf("cannot combine --copy-out with --detach; run docker cp once the container is done instead")
+	}
+	for _, c := range opts.CopyOut {
+		if _, _, err := splitCopyOut(c); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Events != "" && opts.Detach {
+		return nil, fmt.Errorf("cannot combine --events with --detach; run docker events --filter container=<name> instead")
+	}
+
+	if opts.Persist && opts.Name == "" {
+		return nil, fmt.Errorf("--persist needs --name")
+	}
+
+	switch opts.BranchPolicy {
+	case BranchKeep, BranchDeleteMerged:
+	case BranchDelete:
+		if opts.KeepWorktree {
+			return nil, fmt.Errorf("cannot delete the branch of a kept worktree")
+		}
+	default:
+		return nil, fmt.Errorf("unknown branch policy: %s", opts.BranchPolicy)
+	}
+
+	var fileLabels []string
+	for _, path := range opts.LabelFiles {
+		labels, err := readLabelFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileLabels = append(fileLabels, labels...)
+	}
+	opts.Labels = append(fileLabels, opts.Labels...)
+	for _, l := range opts.Labels {
+		if err := validateLabel(l); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range opts.BuildArgs {
+		if err := validateBuildArg(a); err != nil {
+			return nil, err
+		}
+	}
+
+	// Locale: explicit, else the host's
+	locale := opts.Locale
+	if locale == "" {
+		locale = os.Getenv("LANG")
+	}
+
+	// A home other than the default is passed to the image build too,
+	// which tags it as a variant like any other build argument. So is a
+	// locale the image doesn't have, since it only generates one.
+	buildArgs := opts.BuildArgs
+	if containerHome != devHome {
+		buildArgs = append([]string{"USER_HOME=" + containerHome}, buildArgs...)
+	}
+	if imageLacksLocale(locale) && opts.Registry == "" {
+		buildArgs = append([]string{"LANG=" + locale}, buildArgs...)
+	}
+
+	capDrop := opts.CapDrop
+	if capDrop == nil {
+		capDrop = []string{"ALL"}
+	}
+	capDrop, capAdd, err := normalizeCaps(capDrop, opts.CapAdd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default command resolution: option > env > image CMD
+	defaultCmd := opts.DefaultCmd
+	if defaultCmd == "" {
+		defaultCmd = os.Getenv("DEVCONTAINER_DEFAULT_CMD")
+	}
+	defaultArgs, err := splitArgs(defaultCmd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default command %q: %w", defaultCmd, err)
+	}
+	if opts.Model != "" && len(defaultArgs) > 0 && !resuming && !opts.Shell && len(opts.Args) == 0 {
+		return nil, fmt.Errorf("cannot combine --model with a default command (--default-cmd or $DEVCONTAINER_DEFAULT_CMD), which is run as given")
+	}
+
+	// Build context directory, relative to the repo root, whose own
+	// Dockerfile is built instead of the embedded one
+	var buildContextDir string
+	buildDockerfile := dockerfile
+	tagArgs := buildArgs
+	if opts.BuildContext != "" {
+		buildContextDir = opts.BuildContext
+		if !filepath.IsAbs(buildContextDir) {
+			buildContextDir = filepath.Join(workspaceDir, buildContextDir)
+		}
+		if !isDir(buildContextDir) {
+			return nil, fmt.Errorf("build context %s is not a directory", buildContextDir)
+		}
+		path := filepath.Join(buildContextDir, ".devcontainer", "Dockerfile")
+		if buildDockerfile, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("build context %s: the embedded Dockerfile can't COPY from it, so it needs a .devcontainer/Dockerfile: %w", buildContextDir, err)
+		}
+		// Tagged as a variant, so it doesn't replace the shared image
+		tagArgs = append(slices.Clone(buildArgs), "DEVCONTAINER_DOCKERFILE="+path)
+	}
+
+	var pullArgs []string
+	if opts.Registry == "" {
+		pullArgs, err = opts.pullPolicyArgs(ctx, opts.PullPolicy, buildDockerfile)
+		if err != nil {
+			return nil, err
+		}
+	} else if r := opts.Registry; strings.ContainsAny(r, " \t\n") || strings.Contains(r, "://") || strings.HasSuffix(r, "/") {
+		return nil, fmt.Errorf("invalid --registry %q: expected a registry and optional path, e.g. ghcr.io/me", r)
+	}
+
+	containerName := envOrDefault("CONTAINER_NAME", "claude-dev")
+	baseImageName := envOrDefault("IMAGE_NAME", "claude-devcontainer")
+	imageName := imageTag(baseImageName, tagArgs)
+
+	// Reconnect to a devcontainer already running for this workspace
+	if opts.NoNewContainer {
+		name, err := ResolveContainer(ctx, ListOptions{Runtime: opts.Runtime, Workspace: workspaceDir, WorkspaceStyle: opts.WorkspaceStyle, Prefix: prefix}, opts.Name, "")
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: attaching to running container %s\n", name)
+			exitCode, err := opts.execShell(ctx, name, "", true)
+			if err != nil {
+				return nil, err
+			}
+			return &Result{ContainerName: name, ExitCode: exitCode}, nil
+		}
+		if !errors.Is(err, ErrNoContainer) {
+			return nil, err
+		}
+	}
+
+	if opts.Docker {
+		if err := confirmDockerSocket(opts.Yes || os.Getenv("DEVCONTAINER_YES") != ""); err != nil {
+			return nil, err
+		}
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("getting home dir: %w", err)
+	}
+
+	// containerWorkspace is the path where the workspace appears inside the
+	// container.  Using the host path lets Claude Code share session data
+	// between host and container (sessions are keyed by absolute path).
+	// hostWorkspace stays the original workspace after workspaceDir
+	// switches to the worktree.
+	hostWorkspace := workspaceDir
+	containerWorkspace := workspaceDir
+	if opts.MountWorkspaceAt != "" {
+		containerWorkspace = filepath.Clean(opts.MountWorkspaceAt)
+	}
+
+	// Resolve a session name or summary to its ID. Sessions are keyed by
+	// the container path.
+	resume := opts.Resume
+	if resume != "" {
+		resume, err = resolveResume(resume, claudeProjectDir(homeDir, containerWorkspace))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vcsName := opts.VCS
+	if gitDir != "" && vcsName == "" {
+		vcsName = "git"
+	}
+	vcs, err := detectVCS(vcsName, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CopyOnWrite {
+		vcs = "copy"
+	}
+	if opts.Track != "" {
+		if vcs != "git" && vcs != "jj" {
+			return nil, fmt.Errorf("--track needs a git or jj workspace")
+		}
+		if opts.IncludeDirty {
+			return nil, fmt.Errorf("cannot combine --track with --include-dirty")
+		}
+	}
+	if opts.Persist && vcs != "git" && vcs != "jj" {
+		return nil, fmt.Errorf("--persist needs a git or jj workspace")
+	}
+
+	var wt *worktree
+	if vcs != "" {
+		wtOpts := worktreeOptions{
+			vcs:            vcs,
+			workspace:      workspaceDir,
+			gitDir:         gitDir,
+			prefix:         prefix,
+			name:           opts.Name,
+			force:          opts.Force,
+			includeDirty:   opts.IncludeDirty,
+			track:          opts.Track,
+			nameFromBranch: opts.NameFromBranch,
+			persist:        opts.persistWorktree(vcs),
+			assumeYes:      opts.Yes || os.Getenv("DEVCONTAINER_YES") != "",
+		}
+		// A named worktree is recreated in place, which would pull it out
+		// from under a container still using it.
+		if opts.Name != "" {
+			if name := prefix + "-" + opts.Name; opts.containerRunning(ctx, name) {
+				return nil, fmt.Errorf("devcontainer %s is still running on worktree %s; attach with 'exec %s', stop it first, or pick another --name", name, wtOpts.namedDir(), opts.Name)
+			}
+		}
+		wt, err = createWorktree(ctx, wtOpts)
+		if err != nil {
+			return nil, err
+		}
+		containerName = prefix + "-" + wt.suffix
+		workspaceDir = wt.dir
+		// A kept container can only be restarted with its mounts in place
+		wt.keep = opts.KeepWorktree || opts.KeepContainer
+		wt.branchPolicy = opts.BranchPolicy
+		wt.verbose = opts.Verbose
+	}
+	result := &Result{ContainerName: containerName}
+	if wt != nil {
+		result.WorktreeDir = wt.dir
+		result.Branch = wt.branch
+	}
+
+	// Build image, or pull it where it was built elsewhere
+	if opts.Registry != "" {
+		// The shared default image is kept under its registry name too
+		baseImageName = opts.Registry + "/" + baseImageName
+		imageName = opts.Registry + "/" + imageName
+		if err := opts.pullImage(ctx, imageName, opts.PullPolicy); err != nil {
+			wt.cleanup(ctx)
+			return nil, err
+		}
+		opts.checkImageIDs(ctx, imageName)
+	} else {
+		if err := opts.buildImage(ctx, imageBuild{
+			imageName:  imageName,
+			pullArgs:   pullArgs,
+			buildArgs:  buildArgs,
+			contextDir: buildContextDir,
+			dockerfile: buildDockerfile,
+			quiet:      opts.Quiet,
+		}); err != nil {
+			wt.cleanup(ctx)
+			return nil, fmt.Errorf("docker build: %w", err)
+		}
+		// A builder that doesn't load its images into the daemon (such as
+		// a buildx docker-container or remote driver) leaves docker run
+		// trying to pull an image no registry has
+		if opts.Context != "" && opts.command(ctx, "image", "inspect", imageName).Run() != nil {
+			wt.cleanup(ctx)
+			return nil, fmt.Errorf("image %s is missing on docker context %q after building it: %s", imageName, opts.Context, imageMissingAdvice)
+		}
+	}
+
+	// Remove pre-existing container, but only if it belongs to this workspace
+	if err := opts.removeStaleContainer(ctx, containerName, wsLabel); err != nil {
+		wt.cleanup(ctx)
+		return nil, err
+	}
+
+	// Trust the container workspace path in claude.json
+	claudeJSON := filepath.Join(homeDir, ".claude.json")
+	if err := trustWorkspace(claudeJSON, containerWorkspace); err != nil {
+		// Non-fatal: warn and continue
+		fmt.Fprintf(os.Stderr, "warning: could not update %s: %v\n", claudeJSON, err)
+	}
+
+	// Build mount and env arguments
+	ms := opts.assembleMounts(ctx, mountPaths{
+		homeDir:            homeDir,
+		containerHome:      containerHome,
+		hostWorkspace:      hostWorkspace,
+		workspaceDir:       workspaceDir,
+		containerWorkspace: containerWorkspace,
+		wt:                 wt,
+		caCert:             caCert,
+	}, false)
+	defer ms.close()
+	mounts := ms.made()
+	envArgs := ms.env
+
+	// Claude Code configuration from the host environment. Only the names
+	// go on the command line; docker reads the values from its environment.
+	if opts.EnvPassthroughClaude {
+		names := claudeEnvNames(os.Environ())
+		for _, name := range names {
+			envArgs = append(envArgs, "-e", name)
+		}
+		if opts.Verbose && len(names) > 0 {
+			fmt.Fprintf(os.Stderr, "devcontainer: forwarding %s\n", strings.Join(names, ", "))
+		}
+	}
+
+	// Proxy settings, forwarded by name like the Claude variables
+	if opts.Proxy {
+		names := proxyEnvNames(os.Environ())
+		for _, name := range names {
+			envArgs = append(envArgs, "-e", name)
+		}
+		if opts.Verbose && len(names) > 0 {
+			fmt.Fprintf(os.Stderr, "devcontainer: forwarding %s\n", strings.Join(names, ", "))
+		}
+	}
+
+	if locale != "none" && locale != "" {
+		envArgs = append(envArgs, "-e", "LANG="+locale, "-e", "LC_ALL="+locale)
+	}
+
+	// Host timezone
+	if tz := detectTimezone(); tz != "" {
+		envArgs = append(envArgs, "-e", "TZ="+tz)
+	}
+
+	// Post-create hook, from the working copy's .devcontainer mounted over
+	// the worktree's: the image entrypoint runs it before the command
+	if fileExists(filepath.Join(hostWorkspace, ".devcontainer", "post-create.sh")) {
+		envArgs = append(envArgs, "-e", "DEVCONTAINER_POST_CREATE="+containerWorkspace+"/.devcontainer/post-create.sh")
+		if buildContextDir != "" && !bytes.Contains(buildDockerfile, []byte("DEVCONTAINER_POST_CREATE")) {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: skipping .devcontainer/post-create.sh: the --build-context Dockerfile has no entrypoint that runs $DEVCONTAINER_POST_CREATE\n")
+		}
+	}
+
+	// Enforce the repository's mount allowlist on everything from the host
+	// beyond the workspace and the files made for this run
+	if cfg.AllowedMounts != nil {
+		if err := cfg.checkMounts(ms.reviewed(), homeDir, hostWorkspace, workspaceDir); err != nil {
+			wt.cleanup(ctx)
+			return nil, err
+		}
+	}
+
+	// Mount a throwaway copy of ~/.claude.json the container may change;
+	// assembleMounts has made it read-only for ReadOnlyClaudeJSON
+	if opts.CopyClaudeConfig {
+		for i, m := range mounts {
+			if m.dst != containerHome+"/.claude.json" {
+				continue
+			}
+			copied, err := copyClaudeJSON(claudeJSON)
+			if err != nil {
+				wt.cleanup(ctx)
+				return nil, err
+			}
+			if !opts.Detach {
+				defer os.Remove(copied)
+			}
+			mounts[i].src = copied
+			break
+		}
+	}
+
+	// Build docker run args
+	dockerArgs := []string{"run"}
+	if opts.Freeze == "" && len(opts.CopyOut) == 0 && !opts.KeepContainer {
+		// A frozen container must outlive its command until it is
+		// committed, and one to copy files out of until they're copied
+		dockerArgs = append(dockerArgs, "--rm")
+	}
+	dockerArgs = append(dockerArgs,
+		"--security-opt=no-new-privileges",
+		"--label", "claude-devcontainer.workspace="+wsLabel,
+		"-w", containerWorkspace,
+		"--name", containerName,
+	)
+	if opts.CIDFile != "" {
+		dockerArgs = append(dockerArgs, "--cidfile", opts.CIDFile)
+	}
+
+	for _, l := range opts.Labels {
+		dockerArgs = append(dockerArgs, "--label", l)
+	}
+
+	if stopSignal != "" {
+		dockerArgs = append(dockerArgs, "--stop-signal="+stopSignal)
+	}
+	if opts.StopTimeout > 0 {
+		dockerArgs = append(dockerArgs, "--stop-timeout="+strconv.Itoa(opts.StopTimeout))
+	}
+
+	if opts.HealthCmd != "" {
+		dockerArgs = append(dockerArgs, "--health-cmd", opts.HealthCmd)
+		if opts.HealthInterval != "" {
+			dockerArgs = append(dockerArgs, "--health-interval="+opts.HealthInterval)
+		}
+		if opts.HealthRetries > 0 {
+			dockerArgs = append(dockerArgs, "--health-retries="+strconv.Itoa(opts.HealthRetries))
+		}
+	}
+
+	for _, c := range capDrop {
+		dockerArgs = append(dockerArgs, "--cap-drop="+c)
+	}
+	for _, c := range capAdd {
+		dockerArgs = append(dockerArgs, "--cap-add="+c)
+	}
+
+	// Attach stdin unless disabled or detached, allocating a TTY if it is
+	// a terminal
+	if opts.Detach {
+		dockerArgs = append(dockerArgs, "-d")
+	} else if !opts.NoStdin {
+		dockerArgs = append(dockerArgs, "-i")
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			dockerArgs = append(dockerArgs, "-t")
+		}
+	}
+
+	// Rootless podman maps the host user to root in the container unless
+	// told to keep the host UID, which breaks ownership of bind mounts.
+	if opts.Userns != "" {
+		dockerArgs = append(dockerArgs, "--userns="+opts.Userns)
+	} else if opts.ContainerBackend(ctx) == "podman" {
+		dockerArgs = append(dockerArgs, "--userns=keep-id")
+	}
+
+	// Resource limits, also recorded as labels so the container's
+	// configuration can be reproduced from docker inspect
+	pidsLimit := ""
+	if opts.PidsLimit > 0 {
+		pidsLimit = strconv.Itoa(opts.PidsLimit)
+	}
+	for _, l := range []struct{ flag, value string }{
+		{"memory", opts.Memory},
+		{"memory-swap", opts.MemorySwap},
+		{"memory-reservation", opts.MemoryReservation},
+		{"pids-limit", pidsLimit},
+		{"shm-size", opts.ShmSize},
+	} {
+		if l.value != "" {
+			dockerArgs = append(dockerArgs,
+				"--"+l.flag+"="+l.value,
+				"--label", "claude-devcontainer."+l.flag+"="+l.value,
+			)
+		}
+	}
+
+	for _, m := range mounts {
+		dockerArgs = append(dockerArgs, "-v", m.arg())
+	}
+	dockerArgs = append(dockerArgs, envArgs...)
+	for _, p := range opts.Ports {
+		dockerArgs = append(dockerArgs, "-p", p)
+	}
+	if opts.PublishAll {
+		dockerArgs = append(dockerArgs, "-P")
+	}
+	for _, t := range opts.Tmpfs {
+		dockerArgs = append(dockerArgs, "--tmpfs", t)
+	}
+	if len(opts.Devices) > 0 {
+		// The image's user has the host user's primary group, so only
+		// other groups owning a device need adding
+		_, gid, err := hostIDs()
+		if err != nil {
+			wt.cleanup(ctx)
+			return nil, err
+		}
+		for _, d := range opts.Devices {
+			dockerArgs = append(dockerArgs, "--device", d)
+		}
+		for _, g := range deviceGroups(opts.Devices, gid) {
+			dockerArgs = append(dockerArgs, "--group-add", g)
+		}
+	}
+	for _, v := range ms.volumes() {
+		dockerArgs = append(dockerArgs, "-v", v)
+	}
+	dockerArgs = append(dockerArgs, imageName)
+	if resuming {
+		dockerArgs = append(dockerArgs, "claude")
+		dockerArgs = append(dockerArgs, permissionArgs...)
+		dockerArgs = append(dockerArgs, "--resume")
+		if resume != "" {
+			dockerArgs = append(dockerArgs, resume)
+		}
+		dockerArgs = append(dockerArgs, resumeArgs...)
+	} else if opts.Shell {
+		dockerArgs = append(dockerArgs, "bash", "-l")
+	} else if len(opts.Args) > 0 {
+		dockerArgs = append(dockerArgs, opts.Args...)
+	} else if len(defaultArgs) > 0 {
+		dockerArgs = append(dockerArgs, defaultArgs...)
+	} else if (opts.PermissionMode != "" && opts.PermissionMode != "skip") || opts.Model != "" {
+		// The image's CMD skips permissions with the default model; run
+		// claude with the flags asked for instead
+		dockerArgs = append(dockerArgs, "claude")
+		dockerArgs = append(dockerArgs, permissionArgs...)
+	}
+
+	// docker run's own errors, such as a mount being denied, are printed
+	// before the container's output, so the head of stderr is enough to
+	// explain them
+	runStderr := &headBuffer{max: 4096}
+	verify := append(slices.Clip(mounts), ms.volumeMounts()...)
+	if opts.Detach {
+		dockerCmd := opts.command(ctx, dockerArgs...)
+		dockerCmd.Stderr = io.MultiWriter(os.Stderr, runStderr)
+		out, err := dockerCmd.Output()
+		if err != nil {
+			wt.cleanup(ctx)
+			if hint := wt.mountDeniedHint(runStderr.String()); hint != "" {
+				return nil, fmt.Errorf("docker run: %w (%s)", err, hint)
+			}
+			if hint := imageMissingHint(runStderr.String(), imageName); hint != "" {
+				return nil, fmt.Errorf("docker run: %w (%s)", err, hint)
+			}
+			if hint := opts.contextMountHint(); hint != "" {
+				return nil, fmt.Errorf("docker run: %w (%s)", err, hint)
+			}
+			return nil, fmt.Errorf("docker run: %w", err)
+		}
+		result.ContainerID = strings.TrimSpace(string(out))
+		if opts.Wait {
+			if err := opts.waitReady(ctx, containerName, opts.WaitTimeout); err != nil {
+				if errors.Is(err, errContainerGone) {
+					// --rm removed the container; nothing uses the worktree
+					wt.cleanup(ctx)
+				}
+				return nil, err
+			}
+		}
+		if err := opts.inspectNetwork(ctx, result); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: %v\n", err)
+		}
+		if err := opts.verifyMounts(ctx, os.Stderr, containerName, verify, opts.Verbose); err != nil && opts.Verbose {
+			fmt.Fprintf(os.Stderr, "devcontainer: couldn't verify the mounts: %v\n", err)
+		}
+		return result, nil
+	}
+
+	// Run docker as subprocess with signal forwarding, stopped like an
+	// interrupted run once the timeout passes
+	runCtx := opts.attachedContext(ctx)
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, opts.Timeout)
+		defer cancel()
+	}
+	dockerCmd := opts.command(runCtx, dockerArgs...)
+	if !opts.NoStdin {
+		dockerCmd.Stdin = os.Stdin
+	}
+	// With a TTY, the container's stderr arrives on stdout
+	perms := &permissionWatch{}
+	dockerCmd.Stdout = io.MultiWriter(os.Stdout, perms)
+	dockerCmd.Stderr = io.MultiWriter(os.Stderr, runStderr, perms)
+	if opts.StopTimeout > 0 {
+		// Give the container its full stop timeout before killing docker
+		dockerCmd.WaitDelay = time.Duration(opts.StopTimeout+5) * time.Second
+	}
+
+	stopEvents := func() {}
+	if opts.Events != "" {
+		events, err := openEvents(opts.Events)
+		if err != nil {
+			wt.cleanup(ctx)
+			return nil, err
+		}
+		defer events.Close()
+		if stopEvents, err = opts.watchEvents(ctx, containerName, events); err != nil {
+			wt.cleanup(ctx)
+			return nil, err
+		}
+	}
+
+	stopPorts := func() {}
+	if opts.PublishAll {
+		stopPorts = opts.reportPorts(ctx, containerName)
+	}
+	// The session has the terminal by the time the container is up, so
+	// the mount check's findings wait until it is over
+	var mountReport bytes.Buffer
+	verified := false
+	stopVerify := whenUp(ctx, func(ctx context.Context) bool {
+		verified = opts.verifyMounts(ctx, &mountReport, containerName, verify, opts.Verbose) == nil
+		return verified
+	})
+
+	var kill func()
+	if !opts.NoInterruptKill {
+		kill = func() { opts.command(context.WithoutCancel(ctx), "kill", containerName).Run() }
+	}
+	result.ExitCode, err = opts.runForwardingSignals(runCtx, dockerCmd, "docker", kill)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if timedOut {
+		// The container may outlive the docker client if it ignored the
+		// relayed SIGTERM
+		fmt.Fprintf(os.Stderr, "devcontainer: %s timed out after %s; killing it\n", containerName, opts.Timeout)
+		opts.command(context.WithoutCancel(ctx), "kill", containerName).Run()
+	}
+	stopEvents()
+	stopPorts()
+	stopVerify()
+	if !verified {
+		// A short run may be over before the first check; a container
+		// without --rm can still be checked
+		mountReport.Reset()
+		opts.verifyMounts(ctx, &mountReport, containerName, verify, opts.Verbose)
+	}
+	os.Stderr.Write(mountReport.Bytes())
+	if hint := wt.mountDeniedHint(runStderr.String()); err == nil && result.ExitCode == 125 && hint != "" {
+		fmt.Fprintf(os.Stderr, "devcontainer: %s\n", hint)
+	} else if hint := imageMissingHint(runStderr.String(), imageName); err == nil && result.ExitCode == 125 && hint != "" {
+		fmt.Fprintf(os.Stderr, "devcontainer: %s\n", hint)
+	} else if hint := opts.contextMountHint(); err == nil && result.ExitCode == 125 && hint != "" {
+		// 125 is docker run's own failure, e.g. a bind mount source
+		// missing on a remote context's host
+		fmt.Fprintf(os.Stderr, "devcontainer: %s\n", hint)
+	}
+	if len(opts.Devices) > 0 && err == nil && result.ExitCode != 0 && result.ExitCode != 125 && result.ExitCode <= 128 && (perms.sawDenied() || opts.Verbose) {
+		fmt.Fprintf(os.Stderr, "devcontainer: %s\n", deviceHint(opts.Devices))
+	}
+
+	if hint := capHint(capDrop, capAdd); err == nil && result.ExitCode != 0 && result.ExitCode != 125 && result.ExitCode <= 128 && hint != "" && (perms.sawEPERM() || opts.Verbose) {
+		// Exit codes above 128 are deaths by signal, e.g. Ctrl-C, which
+		// capabilities don't explain; other failures only get the hint
+		// if the output shows an EPERM
+		fmt.Fprintf(os.Stderr, "devcontainer: %s\n", hint)
+	}
+
+	if len(opts.CopyOut) > 0 {
+		opts.copyOut(context.WithoutCancel(ctx), containerName, containerWorkspace, opts.CopyOut)
+	}
+	if opts.Freeze != "" {
+		opts.freezeContainer(context.WithoutCancel(ctx), containerName, opts.Freeze, err == nil, result)
+	} else if opts.KeepContainer {
+		if err == nil && result.ExitCode != 0 {
+			fmt.Fprintf(os.Stderr, "devcontainer: kept container %s, which exited with %d; restart and attach to it with 'exec --start %s', and remove it with 'stop --rm %s'\n", containerName, result.ExitCode, containerName, containerName)
+		} else {
+			fmt.Fprintf(os.Stderr, "devcontainer: kept container %s; remove it with 'stop --rm %s'\n", containerName, containerName)
+		}
+		if wt != nil {
+			wt.containerKept = true
+		}
+	} else if len(opts.CopyOut) > 0 {
+		if out, err := opts.command(context.WithoutCancel(ctx), "rm", "-f", containerName).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: removing container %s: %s\n", containerName, strings.TrimSpace(string(out)))
+		}
+	}
+
+	// Cleanup worktree
+	if failed := err != nil || timedOut || result.ExitCode != 0; opts.KeepOnFailure && failed && wt != nil && !wt.keep && !wt.persistent && !wt.containerKept {
+		wt.keep = true
+		fmt.Fprintf(os.Stderr, "devcontainer: kept worktree %s since the command failed\n", wt.dir)
+	}
+	wt.cleanup(ctx)
+
+	if script := filepath.Join(hostWorkspace, ".devcontainer", "post-stop.sh"); (err == nil || timedOut) && fileExists(script) {
+		exitCode := result.ExitCode
+		if timedOut {
+			exitCode = TimeoutExitCode
+		}
+		runPostStop(ctx, script, wt, containerName, exitCode)
+	}
+
+	// Remove a per-build-argument image variant; the shared image stays,
+	// and so does a pulled one, which can't be rebuilt here
+	if opts.RemoveImage && imageName != baseImageName && opts.Registry == "" {
+		rmCmd := opts.command(context.WithoutCancel(ctx), "image", "rm", imageName)
+		if out, rmErr := rmCmd.CombinedOutput(); rmErr != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: removing image %s: %s\n", imageName, strings.TrimSpace(string(out)))
+		}
+	}
+
+	if timedOut {
+		result.ExitCode = TimeoutExitCode
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// postStopTimeout bounds the post-stop hook so a hung script can't keep
+// devcontainer from exiting.
+var postStopTimeout = 5 * time.Minute
+
+// runPostStop runs the workspace's post-stop hook on the host once the
+// container has exited and its worktree has been cleaned up, passing the
+// worktree path, its branch (the jj workspace name for jj), and the exit
+// code as arguments and as $DEVCONTAINER_WORKTREE, $DEVCONTAINER_BRANCH,
+// and $DEVCONTAINER_EXIT_CODE. The script is read from the host working
+// copy, which the container can't modify. It is best-effort: a failure is
+// only reported.
+func runPostStop(ctx context.Context, script string, wt *worktree, containerName string, exitCode int) {
+	var dir, branch string
+	if wt != nil {
+		dir, branch = wt.dir, wt.branch
+		if wt.jjWorkspace != "" {
+			branch = wt.jjWorkspace
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), postStopTimeout)
+	defer cancel()
+	code := strconv.Itoa(exitCode)
+	cmd := execCommand(ctx, "bash", script, dir, branch, code)
+	cmd.Dir = filepath.Dir(filepath.Dir(script))
+	cmd.Env = append(cmd.Environ(),
+		"DEVCONTAINER_WORKTREE="+dir,
+		"DEVCONTAINER_BRANCH="+branch,
+		"DEVCONTAINER_EXIT_CODE="+code,
+		"DEVCONTAINER_CONTAINER="+containerName,
+	)
+	// Stdout may carry --output json
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: post-stop hook %s timed out after %s\n", script, postStopTimeout)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "devcontainer: warning: post-stop hook %s: %v\n", script, err)
+	}
+}
+
+// inspectNetwork fills in the IP addresses and published ports of the
+// running container in result.
+func (r Runtime) inspectNetwork(ctx context.Context, result *Result) error {
+	out, err := r.command(ctx, "inspect", "--format", "{{json .NetworkSettings}}", result.ContainerName).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting container %s: %w", result.ContainerName, err)
+	}
+	var settings struct {
+		IPAddress string
+		Networks  map[string]struct{ IPAddress string }
+		Ports     map[string][]struct{ HostIp, HostPort string }
+	}
+	if err := json.Unmarshal(out, &settings); err != nil {
+		return fmt.Errorf("parsing network settings of %s: %w", result.ContainerName, err)
+	}
+
+	result.IPAddress = settings.IPAddress
+	for _, name := range slices.Sorted(maps.Keys(settings.Networks)) {
+		ip := settings.Networks[name].IPAddress
+		if result.Networks == nil {
+			result.Networks = make(map[string]string)
+		}
+		result.Networks[name] = ip
+		if result.IPAddress == "" {
+			result.IPAddress = ip
+		}
+	}
+	for _, port := range slices.Sorted(maps.Keys(settings.Ports)) {
+		// Exposed but unpublished ports have no bindings
+		for _, b := range settings.Ports[port] {
+			result.Ports = append(result.Ports, PortBinding{ContainerPort: port, HostIP: b.HostIp, HostPort: b.HostPort})
+		}
+	}
+	return nil
+}
+
+// claudeEnvNames returns the sorted names of the ANTHROPIC_* and CLAUDE_*
+// variables in environ, which Claude Code reads its configuration from.
+func claudeEnvNames(environ []string) []string {
+	var names []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "ANTHROPIC_") || strings.HasPrefix(name, "CLAUDE_") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// copyOut copies each container-path:host-path of specs out of the
+// exited container name, reporting each copy. Failures are warnings, so
+// one missing artifact doesn't keep the others or the cleanup from
+// happening.
+func (r Runtime) copyOut(ctx context.Context, name, containerWorkspace string, specs []string) {
+	for _, spec := range specs {
+		src, dst, _ := splitCopyOut(spec)
+		if !path.IsAbs(src) {
+			src = path.Join(containerWorkspace, src)
+		}
+		dst, err := filepath.Abs(dst)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(dst), 0755)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: copying out %s: %v\n", src, err)
+			continue
+		}
+		if out, err := r.command(ctx, "cp", name+":"+src, dst).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: copying out %s: %s\n", src, strings.TrimSpace(string(out)))
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "devcontainer: copied %s to %s\n", src, dst)
+	}
+}
+
+// freezeContainer commits the exited container name to the image ref if
+// commit is set, then removes the container as --rm would have. If the
+// commit fails, the container is kept so it can be committed by hand.
+func (r Runtime) freezeContainer(ctx context.Context, name, ref string, commit bool, result *Result) {
+	if commit {
+		out, err := r.command(ctx, "commit", name, ref).Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: freezing %s: %v; the container is kept\n", name, err)
+			return
+		}
+		result.FrozenImage = strings.TrimSpace(string(out))
+		fmt.Fprintf(os.Stderr, "devcontainer: froze %s as %s (%s)\n", name, ref, result.FrozenImage)
+	}
+	if out, err := r.command(ctx, "rm", "-f", name).CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "devcontainer: warning: removing container %s: %s\n", name, strings.TrimSpace(string(out)))
+	}
+}
+
+// copyClaudeJSON copies the claude config at path to a temp file for
+// StartOptions.CopyClaudeConfig and returns the copy's path.
+func copyClaudeJSON(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	f, err := os.CreateTemp("", "claude-json-")
+	if err != nil {
+		return "", fmt.Errorf("copying %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("copying %s: %w", path, err)
+	}
+	return f.Name(), nil
+}
+
+// defaultLocale is the locale the bundled Dockerfile generates unless its
+// LANG build argument names another.
+const defaultLocale = "en_US.UTF-8"
+
+// imageLacksLocale reports whether the image built without a LANG build
+// argument is missing locale, which then has to be passed to the build.
+// Setting none, C, or POSIX needs nothing generated.
+func imageLacksLocale(locale string) bool {
+	switch {
+	case locale == "", locale == "none", locale == defaultLocale, locale == "C", locale == "POSIX", strings.HasPrefix(locale, "C."):
+		return false
+	}
+	return true
+}
+
+// resolveVolume resolves the relative host path of a --volume value
+// against workspaceDir so that Docker treats it as a bind mount instead of
+// a named volume.
+func resolveVolume(v, workspaceDir string) string {
+	if src, rest, ok := strings.Cut(v, ":"); ok && !filepath.IsAbs(src) {
+		return filepath.Join(workspaceDir, src) + ":" + rest
+	}
+	return v
+}
+
+// persistWorktree reports whether the worktree of vcs is to be made
+// persistent: with Persist, or for a named Shell, which reopens a branch's
+// environment to come back to. A copy-on-write copy never is.
+func (opts StartOptions) persistWorktree(vcs string) bool {
+	return opts.Persist || opts.Shell && opts.Name != "" && vcs != "copy"
+}
+
+// headBuffer keeps the first max bytes written to it and discards the
+// rest.
+type headBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := min(len(p), b.max-len(b.buf)); n > 0 {
+		b.buf = append(b.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (b *headBuffer) String() string { return string(b.buf) }
+
+// permissionWatch records whether the output written to it reports a
+// permission error, even one split across writes. It is safe for the
+// concurrent writes of a command's stdout and stderr.
+type permissionWatch struct {
+	mu            sync.Mutex
+	tail          []byte
+	eperm, eacces bool
+}
+
+// epermMessage and eaccesMessage are how EPERM and EACCES read in most
+// tools' error messages.
+const (
+	epermMessage  = "Operation not permitted"
+	eaccesMessage = "Permission denied"
+)
+
+func (w *permissionWatch) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data := append(w.tail, p...)
+	if bytes.Contains(data, []byte(epermMessage)) {
+		w.eperm = true
+	}
+	if bytes.Contains(data, []byte(eaccesMessage)) {
+		w.eacces = true
+	}
+	w.tail = slices.Clone(data[len(data)-min(len(data), len(epermMessage)-1):])
+	return len(p), nil
+}
+
+// sawEPERM reports whether an EPERM error was written.
+func (w *permissionWatch) sawEPERM() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.eperm
+}
+
+// sawDenied reports whether an EPERM or EACCES error was written.
+func (w *permissionWatch) sawDenied() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.eperm || w.eacces
+}
+
+// mountDeniedHint explains a docker run failure whose stderr shows that
+// Docker Desktop refused to mount a path that isn't in its file sharing
+// settings, as happens on macOS when the temp dir holding the worktree
+// isn't shared. It returns "" for other failures or without a worktree.
+func (w *worktree) mountDeniedHint(stderr string) string {
+	if w == nil || !strings.Contains(stderr, "Mounts denied") && !strings.Contains(stderr, "is not shared from the host") {
+		return ""
+	}
+	return fmt.Sprintf("docker could not mount the worktree %s; share %s with Docker "+
+		"(Docker Desktop: Settings > Resources > File sharing), or set TMPDIR to a shared directory "+
+		"such as one under your home so worktrees are created there", w.dir, filepath.Dir(w.dir))
+}
+
+// imageMissingAdvice is what to do when the devcontainer image isn't on
+// the daemon that runs it.
+const imageMissingAdvice = "make the builder load its images into the daemon (docker buildx use default, or a builder with --load), or push the image from where it builds and pass --registry to pull it from there"
+
+// imageMissingHint explains a docker run failure on stderr that says the
+// image doesn't exist, which docker only reports after failing to pull a
+// locally built image from Docker Hub.
+func imageMissingHint(stderr, image string) string {
+	if !strings.Contains(stderr, "Unable to find image") && !strings.Contains(stderr, "pull access denied") && !strings.Contains(stderr, "No such image") {
+		return ""
+	}
+	return fmt.Sprintf("image %s isn't on the docker daemon running the container; %s", image, imageMissingAdvice)
+}
+
+// contextMountHint explains, when r targets an explicit docker context,
+// that bind mounts refer to paths on that context's host.
+func (r Runtime) contextMountHint() string {
+	if r.Context == "" {
+		return ""
+	}
+	return fmt.Sprintf("bind mounts are resolved on the host of docker context %q; "+
+		"if it is remote, the worktree and home directories must exist there at the same paths", r.Context)
+}
+
+// claudePermissionArgs returns the claude flags for a permission mode; see
+// StartOptions.PermissionMode.
+func claudePermissionArgs(mode string) ([]string, error) {
+	switch mode {
+	case "", "skip":
+		return []string{"--dangerously-skip-permissions"}, nil
+	case "prompt":
+		return nil, nil
+	case "default":
+		return []string{"--permission-mode", "default"}, nil
+	}
+	return nil, fmt.Errorf("unknown permission mode: %s (expected 'skip', 'prompt', or 'default')", mode)
+}
+
+// proxyEnvNames returns the sorted names of the proxy variables set in
+// environ.
+func proxyEnvNames(environ []string) []string {
+	var names []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy":
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// confirmDockerSocket warns that mounting the Docker socket hands the
+// container control of the host daemon and, when interactive, asks for
+// confirmation unless assumeYes is set.
+func confirmDockerSocket(assumeYes bool) error {
+	fmt.Fprintln(os.Stderr, "devcontainer: warning: --docker mounts the host Docker socket. Anything in the container can then")
+	fmt.Fprintln(os.Stderr, "devcontainer: start privileged containers and mount host paths, which is equivalent to root on")
+	fmt.Fprintln(os.Stderr, "devcontainer: the host and bypasses --cap-drop=ALL and no-new-privileges.")
+	if assumeYes || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "Mount the Docker socket anyway",
+		IsConfirm: true,
+		Stdout:    os.Stderr,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return fmt.Errorf("aborted: Docker socket mount not confirmed (pass --yes to skip this prompt)")
+	}
+	return nil
+}
+
+// startupTimeout is how long the checks of an attached run wait for its
+// container to come up.
+var startupTimeout = 30 * time.Second
+
+// whenUp calls try every waitPollInterval until it reports being done, for
+// checks of an attached run's container, which docker creates some time
+// after the run starts. It gives up after startupTimeout or when the
+// returned stop is called.
+func whenUp(ctx context.Context, try func(ctx context.Context) bool) (stop func()) {
+	ctx, cancel := context.WithTimeout(ctx, startupTimeout)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		for !try(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// reportPorts prints the host ports docker assigned to the container
+// once it is up, for an attached run, whose Result comes too late to
+// tell. An image that EXPOSEs nothing has none to print.
+func (r Runtime) reportPorts(ctx context.Context, name string) (stop func()) {
+	return whenUp(ctx, func(ctx context.Context) bool {
+		out, err := r.command(ctx, "port", name).Output()
+		ports := strings.TrimSpace(string(out))
+		if err != nil || ports == "" {
+			return false
+		}
+		for _, line := range strings.Split(ports, "\n") {
+			fmt.Fprintf(os.Stderr, "devcontainer: port %s\n", line)
+		}
+		return true
+	})
+}
+
+// waitPollInterval is how often waitReady inspects the container.
+var waitPollInterval = 500 * time.Millisecond
+
+// errContainerGone means a container stopped before it became ready.
+var errContainerGone = errors.New("container exited before becoming ready")
+
+// waitReady waits until container name is running and, if it has a health
+// check, healthy, giving up after timeout unless it is 0. A container that
+// turns unhealthy or times out is left running for inspection.
+func (r Runtime) waitReady(ctx context.Context, name string, timeout time.Duration) error {
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	state := "created"
+	for {
+		out, err := r.command(waitCtx, "inspect", "--format", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", name).Output()
+		if waitCtx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s is gone (run it attached to see its output)", errContainerGone, name)
+		}
+		status, health, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+		switch {
+		case status == "exited" || status == "dead":
+			return fmt.Errorf("%w: %s is %s (see docker logs %s)", errContainerGone, name, status, name)
+		case health == "unhealthy":
+			return fmt.Errorf("container %s is unhealthy; it is left running for inspection (see docker inspect %s)", name, name)
+		case status == "running" && (health == "" || health == "healthy"):
+			return nil
+		}
+		state = status
+		if health != "" {
+			state += ", " + health
+		}
+		select {
+		case <-waitCtx.Done():
+		case <-time.After(waitPollInterval):
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("container %s not ready after %s (still %s); it is left running", name, timeout, state)
+}
+
+// containerRunning reports whether the container name exists and is
+// running. An image of that name doesn't count.
+func (r Runtime) containerRunning(ctx context.Context, name string) bool {
+	out, err := r.command(ctx, "container", "inspect", "--format", "{{.State.Running}}", name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// removeStaleContainer removes an existing container named containerName
+// left over from a previous run. It refuses to remove a container whose
+// workspace label doesn't match workspace, since that container belongs to
+// another repository that happens to derive the same name.
+func (r Runtime) removeStaleContainer(ctx context.Context, containerName, workspace string) error {
+	// docker container inspect, since a bare inspect also matches an image
+	// or volume of that name
+	out, err := r.command(ctx, "container", "inspect",
+		"--format", `{{index .Config.Labels "claude-devcontainer.workspace"}}`,
+		containerName,
+	).Output()
+	if err != nil {
+		// No such container
+		return nil
+	}
+	if owner := strings.TrimSpace(string(out)); owner != workspace {
+		if owner == "" {
+			return fmt.Errorf("container name %q is already in use by a container not managed by devcontainer; set CONTAINER_NAME or --name to pick another name", containerName)
+		}
+		return fmt.Errorf("container name %q is already in use by workspace %s; set CONTAINER_NAME or --name to pick another name", containerName, owner)
+	}
+
+	rmCmd := r.command(ctx, "rm", "-f", containerName)
+	rmCmd.Stdout = nil
+	rmCmd.Stderr = nil
+	rmCmd.Run()
+	return nil
+}
@@ -0,0 +1,1080 @@
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveStaleContainer(t *testing.T) {
+	tests := []struct {
+		name    string
+		inspect func() (string, int)
+		wantRm  bool
+		wantErr string
+	}{
+		{"no container", func() (string, int) { return "", 1 }, false, ""},
+		{"same workspace", func() (string, int) { return "/repo\n", 0 }, true, ""},
+		{"other workspace", func() (string, int) { return "/other\n", 0 }, false, "in use by workspace /other"},
+		{"unmanaged", func() (string, int) { return "\n", 0 }, false, "not managed by devcontainer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeExec(t, func(call []string) (string, int) {
+				switch {
+				case len(call) > 3 && slices.Equal(call[:3], []string{"docker", "container", "inspect"}):
+					return tt.inspect()
+				case slices.Contains(call, "inspect"):
+					// An image of the same name
+					return "/other\n", 0
+				}
+				return "", 0
+			})
+			err := Runtime{}.removeStaleContainer(context.Background(), "claude-dev", "/repo")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("removeStaleContainer: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("removeStaleContainer error = %v, want %q", err, tt.wantErr)
+			}
+			if got := f.ran("docker", "rm", "-f", "claude-dev"); got != tt.wantRm {
+				t.Errorf("removed container = %v, want %v; ran:\n%s", got, tt.wantRm, f)
+			}
+		})
+	}
+}
+
+func TestClaudeEnvNames(t *testing.T) {
+	got := claudeEnvNames([]string{
+		"PATH=/usr/bin",
+		"CLAUDE_CODE_USE_BEDROCK=1",
+		"ANTHROPIC_API_KEY=secret",
+		"MY_CLAUDE_THING=x",
+		"ANTHROPIC_BASE_URL=https://example.com",
+	})
+	want := []string{"ANTHROPIC_API_KEY", "ANTHROPIC_BASE_URL", "CLAUDE_CODE_USE_BEDROCK"}
+	if !slices.Equal(got, want) {
+		t.Errorf("claudeEnvNames = %q, want %q", got, want)
+	}
+}
+
+func TestProxyEnvNames(t *testing.T) {
+	got := proxyEnvNames([]string{
+		"PATH=/usr/bin",
+		"https_proxy=http://proxy:3128",
+		"HTTP_PROXY=http://proxy:3128",
+		"NO_PROXY=localhost",
+		"FTP_PROXY=http://proxy:3128",
+	})
+	want := []string{"HTTP_PROXY", "NO_PROXY", "https_proxy"}
+	if !slices.Equal(got, want) {
+		t.Errorf("proxyEnvNames = %q, want %q", got, want)
+	}
+}
+
+func TestStartNoNewContainerAttaches(t *testing.T) {
+	workspace := t.TempDir()
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") {
+			return `{"ID":"abc","Names":"devcontainer-feature"}` + "\n", 0
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: workspace, NoNewContainer: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ContainerName != "devcontainer-feature" {
+		t.Errorf("ContainerName = %q", result.ContainerName)
+	}
+	if !f.ran("docker", "exec", "-i", "devcontainer-feature", "bash", "-l") {
+		t.Errorf("did not attach; ran:\n%s", f)
+	}
+	if f.ran("docker", "build") || f.ran("docker", "run") {
+		t.Errorf("started a new container; ran:\n%s", f)
+	}
+}
+
+func TestInspectNetwork(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) {
+		return `{"IPAddress":"","Networks":{"dev":{"IPAddress":"10.0.0.5"},"bridge":{"IPAddress":"172.17.0.2"}},` +
+			`"Ports":{"9229/tcp":null,"8080/tcp":[{"HostIp":"0.0.0.0","HostPort":"49153"},{"HostIp":"::","HostPort":"49153"}]}}`, 0
+	})
+	result := &Result{ContainerName: "devcontainer-x"}
+	if err := (Runtime{}).inspectNetwork(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	if result.IPAddress != "172.17.0.2" {
+		t.Errorf("IPAddress = %q, want the first network's", result.IPAddress)
+	}
+	if result.Networks["dev"] != "10.0.0.5" || len(result.Networks) != 2 {
+		t.Errorf("Networks = %v", result.Networks)
+	}
+	want := []PortBinding{
+		{ContainerPort: "8080/tcp", HostIP: "0.0.0.0", HostPort: "49153"},
+		{ContainerPort: "8080/tcp", HostIP: "::", HostPort: "49153"},
+	}
+	if !slices.Equal(result.Ports, want) {
+		t.Errorf("Ports = %+v, want %+v", result.Ports, want)
+	}
+}
+
+// newDetachedStart sets up a fake docker for a detached Start in a fresh
+// home directory.
+func newDetachedStart(t *testing.T) *fakeExec {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	return newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "0123456789abcdef\n", 0
+		case slices.Contains(call, "{{json .NetworkSettings}}"):
+			return `{"IPAddress":"172.17.0.2"}`, 0
+		case slices.Contains(call, "inspect"):
+			return "", 1 // no stale container
+		}
+		return "", 0
+	})
+}
+
+func TestStartDetach(t *testing.T) {
+	f := newDetachedStart(t)
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Ports: []string{"0:8080"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ContainerID != "0123456789abcdef" || result.IPAddress != "172.17.0.2" {
+		t.Errorf("result = %+v", result)
+	}
+	run := f.find("run")
+	if !slices.Contains(run, "-d") || slices.Contains(run, "-i") {
+		t.Errorf("docker run not detached: %q", run)
+	}
+}
+
+func TestStartMountWorkspaceAt(t *testing.T) {
+	f := newDetachedStart(t)
+	os.WriteFile(filepath.Join(os.Getenv("HOME"), ".claude.json"), []byte("{}"), 0644)
+	workspace := t.TempDir()
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, MountWorkspaceAt: "/src/repo/"}); err != nil {
+		t.Fatal(err)
+	}
+	run := strings.Join(f.find("run"), " ")
+	for _, want := range []string{
+		"-v " + workspace + ":/src/repo ",
+		"-w /src/repo ",
+		"--label claude-devcontainer.workspace=" + workspace + " ",
+	} {
+		if !strings.Contains(run, want) {
+			t.Errorf("docker run is missing %q: %s", want, run)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".claude.json"))
+	if !strings.Contains(string(data), `"/src/repo"`) {
+		t.Errorf("trusted workspace not updated: %s", data)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, MountWorkspaceAt: "src"}); err == nil {
+		t.Error("relative --mount-workspace-at accepted")
+	}
+}
+
+func TestStartRemoveImage(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		buildArgs []string
+		registry  string
+		wantRm    bool
+	}{
+		{"shared image", nil, "", false},
+		{"build-arg variant", []string{"GO_VERSION=1.24"}, "", true},
+		{"pulled variant", []string{"GO_VERSION=1.24"}, "ghcr.io/me", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("SSH_AUTH_SOCK", "")
+			f := newFakeExec(t, func(call []string) (string, int) {
+				if slices.Contains(call, "inspect") {
+					return "", 1 // no stale container
+				}
+				return "", 0
+			})
+			_, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, BuildArgs: tt.buildArgs, Registry: tt.registry, RemoveImage: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rm := f.find("rm")
+			if got := rm != nil && slices.Contains(rm, "image"); got != tt.wantRm {
+				t.Errorf("removed image = %v, want %v; ran:\n%s", got, tt.wantRm, f)
+			}
+			if tt.wantRm && !strings.HasPrefix(rm[len(rm)-1], "claude-devcontainer:args-") {
+				t.Errorf("removed %q, want the variant", rm[len(rm)-1])
+			}
+		})
+	}
+}
+
+func TestStartFreeze(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "commit"):
+			return "sha256:0123\n", 0
+		case slices.Contains(call, "inspect"):
+			return "", 1 // no stale container
+		case slices.Contains(call, "run"):
+			return "", 3
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, Freeze: "bug:repro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(f.find("run"), "--rm") {
+		t.Errorf("frozen container run with --rm: %q", f.find("run"))
+	}
+	name := result.ContainerName
+	if !f.ran("docker", "commit", name, "bug:repro") || !f.ran("docker", "rm", "-f", name) {
+		t.Errorf("container not committed and removed; ran:\n%s", f)
+	}
+	if result.FrozenImage != "sha256:0123" || result.ExitCode != 3 {
+		t.Errorf("result = %+v", result)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Freeze: "bug:repro"}); err == nil {
+		t.Error("--freeze accepted with --detach")
+	}
+}
+
+func TestStartCIDFile(t *testing.T) {
+	f := newDetachedStart(t)
+	cidfile := filepath.Join(t.TempDir(), "cid")
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, CIDFile: cidfile}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(f.find("run"), " "), " --cidfile "+cidfile+" ") {
+		t.Errorf("docker run is missing --cidfile: %q", f.find("run"))
+	}
+
+	os.WriteFile(cidfile, []byte("old"), 0644)
+	for _, path := range []string{cidfile, filepath.Join(t.TempDir(), "missing", "cid")} {
+		if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, CIDFile: path}); err == nil {
+			t.Errorf("--cidfile %s accepted", path)
+		}
+	}
+}
+
+func TestStartResumeArgs(t *testing.T) {
+	f := newDetachedStart(t)
+	_, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, ResumeLatest: true, ResumeArgs: `--model opus --append-system-prompt "be brief"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := f.find("run")
+	want := []string{"claude", "--dangerously-skip-permissions", "--resume", "--model", "opus", "--append-system-prompt", "be brief"}
+	if !slices.Equal(run[len(run)-len(want):], want) {
+		t.Errorf("docker run command = %q, want it to end with %q", run, want)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), ResumeArgs: "--model opus"}); err == nil {
+		t.Error("--resume-args accepted without --resume")
+	}
+}
+
+func TestStartPermissionMode(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts StartOptions
+		want []string
+	}{
+		{"image default", StartOptions{}, []string{"claude-devcontainer"}},
+		{"prompt", StartOptions{PermissionMode: "prompt"}, []string{"claude-devcontainer", "claude"}},
+		{"default", StartOptions{PermissionMode: "default"}, []string{"claude-devcontainer", "claude", "--permission-mode", "default"}},
+		{"resume prompt", StartOptions{PermissionMode: "prompt", ResumeLatest: true}, []string{"claude-devcontainer", "claude", "--resume"}},
+		{"default-cmd kept", StartOptions{PermissionMode: "default", DefaultCmd: "bash"}, []string{"claude-devcontainer", "bash"}},
+		{"model", StartOptions{Model: "opus"}, []string{"claude-devcontainer", "claude", "--dangerously-skip-permissions", "--model", "opus"}},
+		{"resume model", StartOptions{Model: "sonnet", PermissionMode: "default", Resume: "abc"}, []string{"claude-devcontainer", "claude", "--permission-mode", "default", "--model", "sonnet", "--resume", "abc"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newDetachedStart(t)
+			tt.opts.Workspace = t.TempDir()
+			tt.opts.Detach = true
+			if _, err := Start(context.Background(), tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			run := f.find("run")
+			if got := run[slices.Index(run, "claude-devcontainer"):]; !slices.Equal(got, tt.want) {
+				t.Errorf("docker run image and command = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), PermissionMode: "yolo"}); err == nil {
+		t.Error("unknown permission mode accepted")
+	}
+	for _, opts := range []StartOptions{
+		{Model: "opus 4"},
+		{Model: "opus", Args: []string{"bash"}},
+		{Model: "opus", DefaultCmd: "bash"},
+	} {
+		opts.Workspace = t.TempDir()
+		if _, err := Start(context.Background(), opts); err == nil {
+			t.Errorf("Start(%+v) accepted", opts)
+		}
+	}
+}
+
+func TestStartCACert(t *testing.T) {
+	f := newDetachedStart(t)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(ca, []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), 0644)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, CACert: ca}); err != nil {
+		t.Fatal(err)
+	}
+	run := strings.Join(f.find("run"), " ")
+	for _, want := range []string{
+		"-v " + ca + ":" + containerCACert + ":ro ",
+		"-e NODE_EXTRA_CA_CERTS=" + containerCACert + " ",
+		"-e SSL_CERT_FILE=" + caBundle + " ",
+		"-e GIT_SSL_CAINFO=" + caBundle + " ",
+	} {
+		if !strings.Contains(run, want) {
+			t.Errorf("docker run is missing %q: %s", want, run)
+		}
+	}
+}
+
+func TestStartLocale(t *testing.T) {
+	for _, tt := range []struct {
+		locale, hostLang string
+		want             string // empty: LANG and LC_ALL not set
+	}{
+		{"", "en_US.UTF-8", "en_US.UTF-8"},
+		{"ja_JP.UTF-8", "en_US.UTF-8", "ja_JP.UTF-8"},
+		{"none", "en_US.UTF-8", ""},
+		{"", "", ""},
+	} {
+		f := newDetachedStart(t)
+		t.Setenv("LANG", tt.hostLang)
+		if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Locale: tt.locale}); err != nil {
+			t.Fatal(err)
+		}
+		run := strings.Join(f.find("run"), " ")
+		if tt.want == "" && strings.Contains(run, "LC_ALL=") {
+			t.Errorf("--locale %q with LANG=%q set the locale: %s", tt.locale, tt.hostLang, run)
+		}
+		if want := "-e LANG=" + tt.want + " -e LC_ALL=" + tt.want + " "; tt.want != "" && !strings.Contains(run, want) {
+			t.Errorf("--locale %q with LANG=%q: docker run is missing %q: %s", tt.locale, tt.hostLang, want, run)
+		}
+		// The image only has en_US.UTF-8 generated unless told otherwise
+		build := f.find("build")
+		if got, want := slices.Contains(build, "LANG="+tt.want), tt.want != "" && tt.want != "en_US.UTF-8"; got != want {
+			t.Errorf("--locale %q with LANG=%q: docker build %q passes LANG: %v, want %v", tt.locale, tt.hostLang, build, got, want)
+		}
+	}
+}
+
+func TestStartMemoryReservation(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, MemoryReservation: "4g"}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Contains(run, "--memory-reservation=4g") {
+		t.Errorf("docker run is missing --memory-reservation: %q", run)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), MemoryReservation: "4 gigs"}); err == nil {
+		t.Error("invalid --memory-reservation accepted")
+	}
+}
+
+func TestStartShmSize(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, ShmSize: "2g"}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Contains(run, "--shm-size=2g") {
+		t.Errorf("docker run is missing --shm-size: %q", run)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), ShmSize: "lots"}); err == nil {
+		t.Error("invalid --shm-size accepted")
+	}
+}
+
+func TestStartHealthCheck(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{
+		Workspace:      t.TempDir(),
+		Detach:         true,
+		HealthCmd:      "curl -f localhost:8080/healthz",
+		HealthInterval: "10s",
+		HealthRetries:  5,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	run := strings.Join(f.find("run"), " ")
+	for _, want := range []string{"--health-cmd curl -f localhost:8080/healthz ", "--health-interval=10s ", "--health-retries=5 "} {
+		if !strings.Contains(run, want) {
+			t.Errorf("docker run is missing %q: %s", want, run)
+		}
+	}
+
+	for _, opts := range []StartOptions{
+		{HealthInterval: "10s"},
+		{HealthCmd: "true", HealthInterval: "10"},
+		{HealthCmd: "true", HealthInterval: "-1s"},
+		{HealthCmd: "true", HealthRetries: -1},
+	} {
+		opts.Workspace = t.TempDir()
+		if _, err := Start(context.Background(), opts); err == nil {
+			t.Errorf("Start(%+v) succeeded, want error", opts)
+		}
+	}
+}
+
+func TestStartContainerHome(t *testing.T) {
+	f := newDetachedStart(t)
+	os.Mkdir(filepath.Join(os.Getenv("HOME"), ".claude"), 0755)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, ContainerHome: "/home/vscode/"}); err != nil {
+		t.Fatal(err)
+	}
+	if build := f.find("build"); !slices.Contains(build, "USER_HOME=/home/vscode") {
+		t.Errorf("docker build is missing USER_HOME: %q", build)
+	}
+	run := strings.Join(f.find("run"), " ")
+	if !strings.Contains(run, ":/home/vscode/.claude ") || strings.Contains(run, "/home/dev/") {
+		t.Errorf("docker run doesn't mount into /home/vscode: %s", run)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), ContainerHome: "home"}); err == nil {
+		t.Error("relative --container-home accepted")
+	}
+}
+
+func TestStartUserns(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Userns: "host", Runtime: Runtime{Backend: "podman"}}); err != nil {
+		t.Fatal(err)
+	}
+	run := f.find("run")
+	if !slices.Contains(run, "--userns=host") || slices.Contains(run, "--userns=keep-id") {
+		t.Errorf("docker run userns = %q", run)
+	}
+}
+
+func TestStartShell(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Shell: true}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Equal(run[len(run)-2:], []string{"bash", "-l"}) {
+		t.Errorf("docker run doesn't open a shell: %q", run)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Shell: true, ResumeLatest: true}); err == nil {
+		t.Error("--shell with --resume accepted")
+	}
+}
+
+func TestPersistWorktree(t *testing.T) {
+	for _, tt := range []struct {
+		opts StartOptions
+		vcs  string
+		want bool
+	}{
+		{StartOptions{Persist: true, Name: "feature"}, "git", true},
+		{StartOptions{Shell: true, Name: "feature"}, "jj", true},
+		{StartOptions{Shell: true}, "git", false},
+		{StartOptions{Shell: true, Name: "feature"}, "copy", false},
+		{StartOptions{Name: "feature"}, "git", false},
+	} {
+		if got := tt.opts.persistWorktree(tt.vcs); got != tt.want {
+			t.Errorf("persistWorktree(%+v, %s) = %v, want %v", tt.opts, tt.vcs, got, tt.want)
+		}
+	}
+}
+
+func TestStartPersistNeedsVCS(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, nil)
+	_, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Name: "feature", Persist: true})
+	if err == nil || !strings.Contains(err.Error(), "git or jj") {
+		t.Errorf("--persist without a VCS: err = %v", err)
+	}
+	if f.ran("run") {
+		t.Errorf("container started; ran:\n%s", f)
+	}
+}
+
+func TestStartWait(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	orig := waitPollInterval
+	waitPollInterval = time.Millisecond
+	t.Cleanup(func() { waitPollInterval = orig })
+
+	states := []string{"created", "running starting", "running healthy"}
+	polls := 0
+	newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "0123456789abcdef\n", 0
+		case slices.ContainsFunc(call, func(arg string) bool { return strings.HasPrefix(arg, "{{.State.Status}}") }):
+			state := states[min(polls, len(states)-1)]
+			polls++
+			return state + "\n", 0
+		case slices.Contains(call, "{{json .NetworkSettings}}"):
+			return `{"Ports":{"8080/tcp":[{"HostIp":"0.0.0.0","HostPort":"32768"}]}}`, 0
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Wait: true, HealthCmd: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != len(states) || len(result.Ports) != 1 || result.Ports[0].HostPort != "32768" {
+		t.Errorf("polled %d times, result = %+v", polls, result)
+	}
+
+	states, polls = []string{"running unhealthy"}, 0
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Wait: true}); err == nil || !strings.Contains(err.Error(), "unhealthy") {
+		t.Errorf("unhealthy container: err = %v", err)
+	}
+	states, polls = []string{"exited"}, 0
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Wait: true}); !errors.Is(err, errContainerGone) {
+		t.Errorf("exited container: err = %v", err)
+	}
+	states, polls = []string{"created"}, 0
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Wait: true, WaitTimeout: 20 * time.Millisecond}); err == nil || !strings.Contains(err.Error(), "not ready after") {
+		t.Errorf("timed out wait: err = %v", err)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Wait: true}); err == nil {
+		t.Error("--wait without --detach accepted")
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "", fakeHang
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != TimeoutExitCode {
+		t.Errorf("ExitCode = %d, want %d", result.ExitCode, TimeoutExitCode)
+	}
+	if !f.ran("docker", "kill", result.ContainerName) {
+		t.Errorf("timed out container not killed; ran:\n%s", f)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Timeout: time.Minute}); err == nil {
+		t.Error("--timeout with --detach accepted")
+	}
+}
+
+func TestStartClaudeJSON(t *testing.T) {
+	f := newDetachedStart(t)
+	home := os.Getenv("HOME")
+	os.WriteFile(filepath.Join(home, ".claude.json"), []byte("{}"), 0644)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, ReadOnlyClaudeJSON: true}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Contains(run, filepath.Join(home, ".claude.json")+":/home/dev/.claude.json:ro") {
+		t.Errorf("~/.claude.json not mounted read-only: %q", run)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, CopyClaudeConfig: true}); err != nil {
+		t.Fatal(err)
+	}
+	copied := ""
+	for _, arg := range f.find("run") {
+		if src, ok := strings.CutSuffix(arg, ":/home/dev/.claude.json"); ok {
+			copied = src
+		}
+	}
+	if copied == "" || copied == filepath.Join(home, ".claude.json") {
+		t.Errorf("~/.claude.json copy not mounted: %q", f.find("run"))
+	} else {
+		os.Remove(copied)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), ReadOnlyClaudeJSON: true, CopyClaudeConfig: true}); err == nil {
+		t.Error("--read-only-claude-json with --copy-claude-config accepted")
+	}
+}
+
+func TestStartNoHostCredentials(t *testing.T) {
+	f := newDetachedStart(t)
+	home := os.Getenv("HOME")
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	os.Mkdir(filepath.Join(home, ".ssh"), 0700)
+	os.WriteFile(filepath.Join(home, ".gitconfig"), nil, 0644)
+	os.Mkdir(filepath.Join(config, "gh"), 0755)
+
+	mounted := func(dst string) bool {
+		return slices.ContainsFunc(f.find("run"), func(arg string) bool { return strings.Contains(arg, ":"+dst+":") })
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, NoSSH: true}); err != nil {
+		t.Fatal(err)
+	}
+	if mounted("/home/dev/.ssh") || !mounted("/home/dev/.gitconfig") || !mounted("/home/dev/.config/gh") {
+		t.Errorf("--no-ssh didn't drop exactly ~/.ssh: %q", f.find("run"))
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, NoGitconfig: true, NoGH: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !mounted("/home/dev/.ssh") || mounted("/home/dev/.gitconfig") || mounted("/home/dev/.config/gh") {
+		t.Errorf("--no-gitconfig --no-gh didn't drop exactly those mounts: %q", f.find("run"))
+	}
+}
+
+func TestStartInvalidRepoPaths(t *testing.T) {
+	newFakeExec(t, nil)
+	for _, opts := range []StartOptions{
+		{Workspace: filepath.Join(t.TempDir(), "missing")},
+		{Workspace: t.TempDir(), GitDir: t.TempDir()},
+		{Workspace: t.TempDir(), GitDir: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := Start(context.Background(), opts); err == nil {
+			t.Errorf("Start(%+v) succeeded, want error", opts)
+		}
+	}
+}
+
+func TestStartNameInUse(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	workspace := t.TempDir()
+	os.Mkdir(filepath.Join(workspace, ".git"), 0755)
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if len(call) > 3 && slices.Equal(call[:3], []string{"docker", "container", "inspect"}) && slices.Contains(call, "{{.State.Running}}") {
+			return "true\n", 0
+		}
+		return "", 0
+	})
+	_, err := Start(context.Background(), StartOptions{Workspace: workspace, Name: "feature"})
+	if err == nil || !strings.Contains(err.Error(), "devcontainer-feature is still running") {
+		t.Fatalf("Start = %v, want a still-running error", err)
+	}
+	if f.ran("git") {
+		t.Errorf("touched the worktree of a running container; ran:\n%s", f)
+	}
+}
+
+func TestStartEvents(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "events"):
+			return `{"status":"start","Action":"start"}` + "\n" + `{"status":"die","Action":"die"}` + "\n", 0
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	os.WriteFile(events, []byte("{}\n"), 0644)
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, Events: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(f.find("events"), "container="+result.ContainerName) {
+		t.Errorf("docker events not filtered to the container: %q", f.find("events"))
+	}
+	if !slices.Contains(f.find("events"), "--since") {
+		t.Errorf("docker events doesn't replay events from before it subscribed: %q", f.find("events"))
+	}
+	data, _ := os.ReadFile(events)
+	if want := "{}\n" + `{"status":"start","Action":"start"}` + "\n" + `{"status":"die","Action":"die"}` + "\n"; string(data) != want {
+		t.Errorf("events file = %q, want %q", data, want)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Events: "-"}); err == nil {
+		t.Error("--events with --detach accepted")
+	}
+}
+
+func TestMountDeniedHint(t *testing.T) {
+	w := &worktree{dir: "/var/folders/xy/T/devcontainer-feature"}
+	stderr := "docker: Error response from daemon: Mounts denied: \nThe path /var/folders/xy/T/devcontainer-feature is not shared from the host and is not known to Docker.\n"
+	if hint := w.mountDeniedHint(stderr); !strings.Contains(hint, "share /var/folders/xy/T with Docker") || !strings.Contains(hint, "TMPDIR") {
+		t.Errorf("mountDeniedHint = %q", hint)
+	}
+	if hint := w.mountDeniedHint("docker: Error response from daemon: No such image\n"); hint != "" {
+		t.Errorf("mountDeniedHint for another error = %q", hint)
+	}
+	if hint := (*worktree)(nil).mountDeniedHint(stderr); hint != "" {
+		t.Errorf("mountDeniedHint without a worktree = %q", hint)
+	}
+}
+
+func TestPermissionWatch(t *testing.T) {
+	w := &permissionWatch{}
+	w.Write([]byte("make: *** [all] Error 1\n"))
+	if w.sawEPERM() {
+		t.Error("sawEPERM after an unrelated error")
+	}
+	w.Write([]byte("ping: socket: Operation not "))
+	w.Write([]byte("permitted\n"))
+	if !w.sawEPERM() {
+		t.Error("sawEPERM = false for an EPERM split across writes")
+	}
+
+	w = &permissionWatch{}
+	w.Write([]byte("cat: /dev/ttyUSB0: Permission denied\n"))
+	if w.sawEPERM() || !w.sawDenied() {
+		t.Errorf("after EACCES: sawEPERM = %v, sawDenied = %v, want only sawDenied", w.sawEPERM(), w.sawDenied())
+	}
+}
+
+func TestStartPostStop(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	workspace := t.TempDir()
+	script := filepath.Join(workspace, ".devcontainer", "post-stop.sh")
+	os.MkdirAll(filepath.Dir(script), 0755)
+	os.WriteFile(script, []byte("exit 1\n"), 0644)
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "", 3
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		case call[0] == "bash":
+			return "", 1
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: workspace, NoStdin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want the container's 3 despite the failing hook", result.ExitCode)
+	}
+	if !f.ran("bash", script, "", "", "3") {
+		t.Errorf("post-stop hook not run; ran:\n%s", f)
+	}
+
+	orig := postStopTimeout
+	postStopTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { postStopTimeout = orig })
+	f.respond = func(call []string) (string, int) { return "", fakeHang }
+	start := time.Now()
+	runPostStop(context.Background(), script, &worktree{dir: "/tmp/wt", branch: "devcontainer-x"}, "devcontainer-x", 0)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("hung post-stop hook ran for %s", elapsed)
+	}
+	if !f.ran("bash", script, "/tmp/wt", "devcontainer-x", "0") {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+}
+
+func TestStartRegistry(t *testing.T) {
+	f := newDetachedStart(t)
+	t.Setenv("IMAGE_NAME", "")
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Registry: "ghcr.io/me"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.ran("docker", "build") || !f.ran("docker", "pull", "ghcr.io/me/claude-devcontainer") {
+		t.Errorf("image not pulled instead of built; ran:\n%s", f)
+	}
+	if run := f.find("run"); !slices.Contains(run, "ghcr.io/me/claude-devcontainer") {
+		t.Errorf("registry image not run: %q", run)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Registry: "ghcr.io/me", PullPolicy: "never"}); err == nil {
+		t.Error("--pull-policy=never pulled a missing image")
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, Registry: "https://ghcr.io/me"}); err == nil {
+		t.Error("accepted a registry URL")
+	}
+}
+
+func TestStartImageMissingOnContext(t *testing.T) {
+	f := newDetachedStart(t)
+	t.Setenv("IMAGE_NAME", "")
+	_, err := Start(context.Background(), StartOptions{Runtime: Runtime{Context: "remote"}, Workspace: t.TempDir(), Detach: true})
+	if err == nil || !strings.Contains(err.Error(), "--registry") {
+		t.Errorf("err = %v, want advice about the missing image", err)
+	}
+	if f.ran("docker", "--context", "remote", "run") {
+		t.Errorf("ran the container without its image; ran:\n%s", f)
+	}
+
+	if hint := imageMissingHint("Unable to find image 'claude-devcontainer:latest' locally\ndocker: Error response from daemon: pull access denied for claude-devcontainer", "claude-devcontainer"); !strings.Contains(hint, "claude-devcontainer isn't on the docker daemon") {
+		t.Errorf("imageMissingHint = %q", hint)
+	}
+	if hint := imageMissingHint("docker: invalid reference format", "x"); hint != "" {
+		t.Errorf("imageMissingHint for another error = %q", hint)
+	}
+}
+
+func TestStartPublishAll(t *testing.T) {
+	f := newDetachedStart(t)
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, PublishAll: true}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Contains(run, "-P") {
+		t.Errorf("-P not passed to docker run: %q", run)
+	}
+
+	// Attached, the ports docker assigned are printed once the container
+	// is up
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+	origInterval := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { waitPollInterval = origInterval })
+	f.respond = func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "port"):
+			return "8080/tcp -> 0.0.0.0:49153\n", 0
+		case slices.Contains(call, "run"):
+			// The session lasts until the timeout, well after the ports are up
+			return "", fakeHang
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	}
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, PublishAll: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.Contains(run, "-P") {
+		t.Errorf("-P not passed to an attached docker run: %q", run)
+	}
+	data, _ := os.ReadFile(stderr.Name())
+	port := strings.Index(string(data), "devcontainer: port 8080/tcp -> 0.0.0.0:49153\n")
+	if timedOut := strings.Index(string(data), "timed out"); port < 0 || port > timedOut {
+		t.Errorf("stderr = %q, want the assigned port printed during the session", data)
+	}
+	if !f.ran("docker", "port", result.ContainerName) {
+		t.Errorf("docker port not queried; ran:\n%s", f)
+	}
+}
+
+func TestStartCopyOut(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		case slices.Contains(call, "cp") && strings.HasSuffix(call[len(call)-2], "/missing"):
+			return "", 1
+		}
+		return "", 0
+	})
+	workspace := t.TempDir()
+	out := t.TempDir()
+	result, err := Start(context.Background(), StartOptions{Workspace: workspace, NoStdin: true, CopyOut: []string{
+		"dist:" + filepath.Join(out, "artifacts", "dist"),
+		"/tmp/missing:" + filepath.Join(out, "missing"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := result.ContainerName
+	if run := f.find("run"); len(run) == 0 || slices.Contains(run, "--rm") {
+		t.Errorf("container to copy out of removed on exit: %q", run)
+	}
+	if !f.ran("docker", "cp", name+":"+workspace+"/dist", filepath.Join(out, "artifacts", "dist")) ||
+		!f.ran("docker", "cp", name+":/tmp/missing") || !f.ran("docker", "rm", "-f", name) {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+	if !isDir(filepath.Join(out, "artifacts")) {
+		t.Error("host destination's parent not created")
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, CopyOut: []string{"dist:dist"}}); err == nil {
+		t.Error("--copy-out with --detach accepted")
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, CopyOut: []string{"dist"}}); err == nil {
+		t.Error("--copy-out without a host path accepted")
+	}
+}
+
+func TestStartKeepOnFailure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	exitCode := 0
+	newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "", exitCode
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	opts := StartOptions{Workspace: t.TempDir(), NoStdin: true, CopyOnWrite: true, KeepOnFailure: true}
+	result, err := Start(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.WorktreeDir == "" || isDir(result.WorktreeDir) {
+		t.Errorf("worktree %q of a successful run not removed", result.WorktreeDir)
+	}
+
+	exitCode = 3
+	result, err = Start(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 || !isDir(result.WorktreeDir) {
+		t.Errorf("worktree %q of a run that exited with %d not kept", result.WorktreeDir, result.ExitCode)
+	}
+	os.RemoveAll(result.WorktreeDir)
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, KeepOnFailure: true}); err == nil {
+		t.Error("--keep-on-failure with --detach accepted")
+	}
+}
+
+func TestStartKeepContainer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "run"):
+			return "", 2
+		case slices.Contains(call, "inspect"):
+			return "", 1
+		}
+		return "", 0
+	})
+	result, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), NoStdin: true, KeepContainer: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); len(run) == 0 || slices.Contains(run, "--rm") {
+		t.Errorf("kept container run with --rm: %q", run)
+	}
+	if f.ran("docker", "rm") || result.ExitCode != 2 {
+		t.Errorf("ExitCode = %d; ran:\n%s", result.ExitCode, f)
+	}
+
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), KeepContainer: true, Freeze: "bug:repro"}); err == nil {
+		t.Error("--keep-container with --freeze accepted")
+	}
+}
+
+func TestStartGoCache(t *testing.T) {
+	f := newDetachedStart(t)
+	modCache := filepath.Join(t.TempDir(), "mod")
+	buildCache := filepath.Join(t.TempDir(), "build")
+	respond := f.respond
+	f.respond = func(call []string) (string, int) {
+		if call[0] == "go" {
+			return modCache + "\n" + buildCache + "\n", 0
+		}
+		return respond(call)
+	}
+	if _, err := Start(context.Background(), StartOptions{Workspace: t.TempDir(), Detach: true, GoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	run := f.find("run")
+	for _, arg := range []string{
+		modCache + ":" + devHome + "/.cache/go-mod",
+		buildCache + ":" + devHome + "/.cache/go-build",
+		"GOMODCACHE=" + devHome + "/.cache/go-mod",
+		"GOCACHE=" + devHome + "/.cache/go-build",
+	} {
+		if !slices.Contains(run, arg) {
+			t.Errorf("docker run lacks %q: %q", arg, run)
+		}
+	}
+	if !isDir(modCache) || !isDir(buildCache) {
+		t.Error("missing cache directories not created")
+	}
+}
+
+func TestStartBuildContext(t *testing.T) {
+	f := newDetachedStart(t)
+	var built string
+	respond := f.respond
+	f.respond = func(call []string) (string, int) {
+		if slices.Contains(call, "build") {
+			// The Dockerfile is only there while the build runs
+			data, _ := os.ReadFile(call[slices.Index(call, "-f")+1])
+			built = string(data)
+		}
+		return respond(call)
+	}
+	workspace := t.TempDir()
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, BuildContext: "."}); err == nil {
+		t.Error("build context without a .devcontainer/Dockerfile accepted")
+	}
+
+	os.MkdirAll(filepath.Join(workspace, ".devcontainer"), 0755)
+	const custom = "FROM ubuntu:24.04\nCOPY requirements.txt /tmp/\n"
+	os.WriteFile(filepath.Join(workspace, ".devcontainer", "Dockerfile"), []byte(custom), 0644)
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, BuildContext: "."}); err != nil {
+		t.Fatal(err)
+	}
+	build := f.find("build")
+	if build[len(build)-1] != workspace || built != custom {
+		t.Errorf("docker build %q built %q, want the context's Dockerfile in %s", build, built, workspace)
+	}
+	if image := build[slices.Index(build, "-t")+1]; !strings.HasPrefix(image, "claude-devcontainer:args-") {
+		t.Errorf("custom image tagged %q, want a variant of the shared image", image)
+	}
+}
+
+func TestStartPostCreate(t *testing.T) {
+	f := newDetachedStart(t)
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+	workspace := t.TempDir()
+	os.MkdirAll(filepath.Join(workspace, ".devcontainer"), 0755)
+	os.WriteFile(filepath.Join(workspace, ".devcontainer", "post-create.sh"), []byte("true\n"), 0644)
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true}); err != nil {
+		t.Fatal(err)
+	}
+	if run := f.find("run"); !slices.ContainsFunc(run, func(arg string) bool { return strings.HasPrefix(arg, "DEVCONTAINER_POST_CREATE=") }) {
+		t.Errorf("post-create hook not passed: %q", run)
+	}
+	if data, _ := os.ReadFile(stderr.Name()); strings.Contains(string(data), "post-create") {
+		t.Errorf("stderr = %q, want no post-create warning with the embedded Dockerfile", data)
+	}
+
+	// A custom Dockerfile without the bundled entrypoint never runs it
+	os.WriteFile(filepath.Join(workspace, ".devcontainer", "Dockerfile"), []byte("FROM ubuntu:24.04\n"), 0644)
+	if _, err := Start(context.Background(), StartOptions{Workspace: workspace, Detach: true, BuildContext: "."}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(stderr.Name()); !strings.Contains(string(data), "devcontainer: warning: skipping .devcontainer/post-create.sh") {
+		t.Errorf("stderr = %q, want the skipped hook warned about", data)
+	}
+}
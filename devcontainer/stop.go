@@ -0,0 +1,136 @@
+package devcontainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// StopOptions configures Stop.
+type StopOptions struct {
+	// ListOptions scopes the containers to stop, e.g. by LabelFilters.
+	ListOptions
+
+	// Target names a single container to stop; see ResolveContainer.
+	Target string
+	// Select picks among several candidates for a single stop without
+	// prompting: "first", "newest", or "oldest".
+	Select string
+	// Each stops every matching container instead of a single one. Stopping
+	// more than one asks for confirmation unless Yes is set.
+	Each bool
+	// Yes skips the confirmation (also $DEVCONTAINER_YES).
+	Yes bool
+	// Remove removes the containers as well, stopped ones included, such
+	// as those kept by StartOptions.KeepContainer, and sets up the
+	// worktrees they mounted for the host again.
+	Remove bool
+}
+
+// Stop stops the devcontainers matching opts and returns their names.
+// Containers started without --freeze are removed by docker once stopped.
+func Stop(ctx context.Context, opts StopOptions) ([]string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Each && opts.Target != "" {
+		return nil, fmt.Errorf("cannot combine a container name with --all")
+	}
+	if opts.Remove {
+		opts.All = true
+	}
+
+	var names []string
+	if opts.Each {
+		containers, err := ListContainers(ctx, opts.ListOptions)
+		if err != nil {
+			return nil, err
+		}
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("%w matches", ErrNoContainer)
+		}
+		for _, c := range containers {
+			names = append(names, c.Names)
+		}
+		if len(names) > 1 {
+			if err := confirmStop(names, opts.Yes || os.Getenv("DEVCONTAINER_YES") != ""); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		name, err := ResolveContainer(ctx, opts.ListOptions, opts.Target, opts.Select)
+		if err != nil {
+			return nil, err
+		}
+		names = []string{name}
+	}
+
+	var errs []error
+	var stopped []string
+	for _, name := range names {
+		if opts.Remove {
+			if err := opts.remove(ctx, name); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		} else if out, err := opts.command(ctx, "stop", name).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %s", name, strings.TrimSpace(string(out))))
+			continue
+		}
+		stopped = append(stopped, name)
+	}
+	return stopped, errors.Join(errs...)
+}
+
+// remove removes the container name and sets up the worktree it mounted,
+// if any, for the host again.
+func (opts StopOptions) remove(ctx context.Context, name string) error {
+	out, err := opts.command(ctx, "inspect", "--format", "{{json .Mounts}}", name).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting mounts of %s: %w", name, err)
+	}
+	var mounts []inspectedMount
+	if err := json.Unmarshal(out, &mounts); err != nil {
+		return fmt.Errorf("parsing mounts of %s: %w", name, err)
+	}
+	if out, err := opts.command(ctx, "rm", "-f", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("removing %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	dir, err := restoreForHost(mounts)
+	if err != nil {
+		return fmt.Errorf("restoring the worktree of %s for the host: %w", name, err)
+	}
+	if dir != "" {
+		fmt.Fprintf(os.Stderr, "devcontainer: kept worktree %s\n", dir)
+	}
+	return nil
+}
+
+// confirmStop asks before stopping several containers. Without a terminal
+// to ask on, assumeYes is required.
+func confirmStop(names []string, assumeYes bool) error {
+	if assumeYes {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("refusing to stop %d devcontainers without confirmation; pass --yes", len(names))
+	}
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("Stop these %d devcontainers", len(names)),
+		IsConfirm: true,
+		Stdout:    os.Stderr,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return fmt.Errorf("aborted: stopping %d devcontainers not confirmed (pass --yes to skip this prompt)", len(names))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package devcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStopEach(t *testing.T) {
+	t.Setenv("DEVCONTAINER_YES", "")
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") {
+			return `{"ID":"abc","Names":"devcontainer-x"}` + "\n" + `{"ID":"def","Names":"devcontainer-y"}` + "\n", 0
+		}
+		return "", 0
+	})
+	opts := StopOptions{ListOptions: ListOptions{LabelFilters: []string{"claude-devcontainer.workspace=/repo"}}, Each: true}
+	if _, err := Stop(context.Background(), opts); err == nil {
+		t.Error("stopped several devcontainers without confirmation")
+	}
+	if f.ran("docker", "stop") {
+		t.Errorf("stopped without confirmation; ran:\n%s", f)
+	}
+
+	opts.Yes = true
+	stopped, err := Stop(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"devcontainer-x", "devcontainer-y"}; !slices.Equal(stopped, want) {
+		t.Errorf("stopped = %q, want %q", stopped, want)
+	}
+	if !f.ran("docker", "ps", "--filter", "label=claude-devcontainer.workspace", "--filter", "label=claude-devcontainer.workspace=/repo") ||
+		!f.ran("docker", "stop", "devcontainer-x") || !f.ran("docker", "stop", "devcontainer-y") {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+}
+
+func TestStopSingle(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "ps") {
+			return `{"ID":"abc","Names":"devcontainer-x"}` + "\n" + `{"ID":"def","Names":"devcontainer-y"}` + "\n", 0
+		}
+		return "", 0
+	})
+	stopped, err := Stop(context.Background(), StopOptions{Target: "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stopped, []string{"devcontainer-y"}) || f.ran("docker", "stop", "devcontainer-x") {
+		t.Errorf("stopped = %q; ran:\n%s", stopped, f)
+	}
+}
+
+func TestStopRemove(t *testing.T) {
+	wt := t.TempDir()
+	gitDir := t.TempDir()
+	// As a kept container leaves it: the gitlink points into the container
+	os.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+dotGitMount+"/worktrees/devcontainer-x\n"), 0644)
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "ps"):
+			return `{"ID":"abc","Names":"devcontainer-x","State":"exited"}` + "\n", 0
+		case slices.Contains(call, "inspect"):
+			return `[{"Type":"bind","Source":"` + wt + `","Destination":"` + wt + `","RW":true},` +
+				`{"Type":"bind","Source":"` + gitDir + `","Destination":"` + dotGitMount + `","RW":true}]`, 0
+		}
+		return "", 0
+	})
+	removed, err := Stop(context.Background(), StopOptions{Target: "x", Remove: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"devcontainer-x"}) || !f.ran("docker", "ps", "-a") || !f.ran("docker", "rm", "-f", "devcontainer-x") || f.ran("docker", "stop") {
+		t.Errorf("removed = %q; ran:\n%s", removed, f)
+	}
+	if data, _ := os.ReadFile(filepath.Join(wt, ".git")); string(data) != "gitdir: "+gitDir+"/worktrees/devcontainer-x\n" {
+		t.Errorf("gitlink = %q, want it restored for the host", data)
+	}
+}
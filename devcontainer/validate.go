@@ -0,0 +1,473 @@
+package devcontainer
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// normalizeCaps validates --cap-drop and --cap-add values, upper-casing
+// them and stripping any CAP_ prefix. Adding back a capability is only
+// coherent after dropping ALL; otherwise a capability listed in both is
+// rejected as a contradiction.
+func normalizeCaps(drop, add []string) ([]string, []string, error) {
+	normalize := func(flag string, caps []string) ([]string, error) {
+		var out []string
+		for _, c := range caps {
+			c = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c)), "CAP_")
+			if c == "" {
+				continue
+			}
+			if strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+				return nil, fmt.Errorf("invalid capability %q in --%s", c, flag)
+			}
+			if !slices.Contains(out, c) {
+				out = append(out, c)
+			}
+		}
+		return out, nil
+	}
+
+	drop, err := normalize("cap-drop", drop)
+	if err != nil {
+		return nil, nil, err
+	}
+	add, err = normalize("cap-add", add)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(add, "ALL") {
+		return nil, nil, fmt.Errorf("--cap-add ALL is not supported; list the capabilities you need")
+	}
+	if !slices.Contains(drop, "ALL") {
+		for _, c := range add {
+			if slices.Contains(drop, c) {
+				return nil, nil, fmt.Errorf("capability %s is in both --cap-drop and --cap-add", c)
+			}
+		}
+	}
+	return drop, add, nil
+}
+
+// devCaps are capabilities that dropping ALL removes and that everyday
+// development tooling commonly needs, with what needs them.
+var devCaps = []struct{ name, use string }{
+	{"NET_RAW", "ping, traceroute"},
+	{"CHOWN", "chown, package installs"},
+	{"DAC_OVERRIDE", "writing files owned by other users"},
+	{"FOWNER", "chmod and utime on others' files"},
+	{"SETUID", "sudo, su"},
+	{"SETGID", "sudo, su"},
+	{"SYS_PTRACE", "gdb, strace, delve"},
+}
+
+// capHint suggests the devCaps that drop removes and add doesn't restore,
+// for when a command in the container fails with a permission error. It
+// returns "" if none are missing.
+func capHint(drop, add []string) string {
+	var missing []string
+	for _, c := range devCaps {
+		if (slices.Contains(drop, "ALL") || slices.Contains(drop, c.name)) && !slices.Contains(add, c.name) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", c.name, c.use))
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "if a command failed with \"Operation not permitted\", it may need a capability back via --cap-add: " + strings.Join(missing, ", ")
+}
+
+// validateDevice checks a --device value of the form
+// host[:container[:permissions]]. The host path must be an existing device
+// node or a directory of them, the container path absolute, and the
+// permissions a combination of r, w, and m as docker expects.
+func validateDevice(spec string) error {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid device %q: expected host[:container[:permissions]]", spec)
+	}
+	host := parts[0]
+	if !filepath.IsAbs(host) {
+		return fmt.Errorf("invalid device %q: host path must be absolute", spec)
+	}
+	info, err := os.Stat(host)
+	if err != nil {
+		return fmt.Errorf("invalid device %q: %w", spec, err)
+	}
+	if info.Mode()&os.ModeDevice == 0 && !info.IsDir() {
+		return fmt.Errorf("invalid device %q: %s is not a device", spec, host)
+	}
+	if len(parts) > 1 && !filepath.IsAbs(parts[1]) {
+		return fmt.Errorf("invalid device %q: container path must be absolute", spec)
+	}
+	if len(parts) > 2 {
+		perms := parts[2]
+		if perms == "" || strings.Trim(perms, "rwm") != "" {
+			return fmt.Errorf("invalid device %q: permissions %q should be a combination of r, w, and m", spec, perms)
+		}
+	}
+	return nil
+}
+
+// deviceGroups returns the groups owning the --device host paths other
+// than gid, the container user's primary group, so the user can be added
+// to them: serial ports and the like are usually only accessible to a
+// group such as dialout, and with the default --cap-drop ALL there's no
+// DAC_OVERRIDE to get around that.
+func deviceGroups(devices []string, gid string) []string {
+	var groups []string
+	for _, d := range devices {
+		host, _, _ := strings.Cut(d, ":")
+		info, err := os.Stat(host)
+		if err != nil {
+			continue
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		g := strconv.FormatUint(uint64(stat.Gid), 10)
+		if g != gid && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// deviceHint is the guidance for when a command in the container fails
+// with devices passed through: access is decided by the device's
+// permissions on the host, which no capability needs to bypass if the
+// container user is in its group.
+func deviceHint(devices []string) string {
+	var hosts []string
+	for _, d := range devices {
+		host, _, _ := strings.Cut(d, ":")
+		hosts = append(hosts, host)
+	}
+	return "if a command couldn't open a --device, check its permissions on the host (ls -l " + strings.Join(hosts, " ") +
+		"): the container user is added to each device's group, but a device only its owner can use needs --cap-add DAC_OVERRIDE, and raw I/O may need --cap-add SYS_RAWIO"
+}
+
+// splitCopyOut splits a --copy-out value of the form
+// container-path:host-path. The container path can't contain a colon, so
+// the first one separates them.
+func splitCopyOut(spec string) (src, dst string, err error) {
+	src, dst, ok := strings.Cut(spec, ":")
+	if !ok || src == "" || dst == "" {
+		return "", "", fmt.Errorf("invalid --copy-out %q: expected container-path:host-path", spec)
+	}
+	return src, dst, nil
+}
+
+// validateTmpfs checks a --tmpfs value of the form path[:options]. The path
+// must be absolute; size and mode options are checked for well-formed
+// values, other mount options are passed through to docker as-is.
+func validateTmpfs(spec string) error {
+	path, options, _ := strings.Cut(spec, ":")
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("invalid tmpfs %q: path must be absolute", spec)
+	}
+	if options == "" {
+		return nil
+	}
+	for _, opt := range strings.Split(options, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "size":
+			if !isByteSize(value) {
+				return fmt.Errorf("invalid tmpfs %q: size %q should be a size such as 64m", spec, value)
+			}
+		case "mode":
+			if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+				return fmt.Errorf("invalid tmpfs %q: mode %q should be octal such as 1777", spec, value)
+			}
+		case "":
+			return fmt.Errorf("invalid tmpfs %q: empty option", spec)
+		}
+	}
+	return nil
+}
+
+// byteSizeRE is the size format docker parses (go-units' RAMInBytes): a
+// number, an optional space, and an optional k, m, g, t, or p unit with
+// optional i and b, in any case, all binary multiples.
+var byteSizeRE = regexp.MustCompile(`^(\d+(\.\d+)*) ?([kKmMgGtTpP])?[iI]?[bB]?$`)
+
+// isByteSize reports whether s is a positive size docker accepts, such as
+// 512m, 1.5g, 8gb, or 512MiB.
+func isByteSize(s string) bool {
+	m := byteSizeRE.FindStringSubmatch(s)
+	if m == nil {
+		return false
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	return err == nil && n > 0
+}
+
+// splitArgs splits s into words the way a POSIX shell would, honoring
+// single quotes, double quotes, and backslash escapes. It does not perform
+// any expansion.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// stopSignals are the signal names accepted by --stop-signal, without the
+// SIG prefix.
+var stopSignals = []string{
+	"ABRT", "ALRM", "BUS", "CHLD", "CONT", "FPE", "HUP", "ILL", "INT", "IO",
+	"KILL", "PIPE", "PROF", "PWR", "QUIT", "SEGV", "STKFLT", "STOP", "SYS",
+	"TERM", "TRAP", "TSTP", "TTIN", "TTOU", "URG", "USR1", "USR2", "VTALRM",
+	"WINCH", "XCPU", "XFSZ",
+}
+
+// normalizeStopSignal validates a --stop-signal value, a signal name with
+// or without the SIG prefix or a signal number, and returns it in the
+// canonical "SIGTERM" or number form.
+func normalizeStopSignal(sig string) (string, error) {
+	if n, err := strconv.Atoi(sig); err == nil {
+		if n < 1 || n > 64 {
+			return "", fmt.Errorf("invalid stop signal %q: signal numbers range from 1 to 64", sig)
+		}
+		return sig, nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(sig), "SIG")
+	if !slices.Contains(stopSignals, name) {
+		return "", fmt.Errorf("invalid stop signal %q: expected a name such as SIGTERM or SIGINT, or a number", sig)
+	}
+	return "SIG" + name, nil
+}
+
+// validateBuildArg checks that a --build-arg value is KEY=VALUE with KEY
+// a valid Dockerfile ARG name.
+func validateBuildArg(arg string) error {
+	key, _, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid build arg %q: expected KEY=VALUE", arg)
+	}
+	for i, r := range key {
+		if r != '_' && !isASCIILetter(r) && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("invalid build arg %q: %q is not a valid ARG name", arg, key)
+		}
+	}
+	return nil
+}
+
+func isASCIILetter(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// validateNameSuffix checks that an exec --name-suffix is made of letters,
+// digits, '.', '_', and '-'.
+func validateNameSuffix(suffix string) error {
+	for _, r := range suffix {
+		if !isASCIILetter(r) && !('0' <= r && r <= '9') && !strings.ContainsRune("._-", r) {
+			return fmt.Errorf("invalid --name-suffix %q: only letters, digits, '.', '_', and '-' are allowed", suffix)
+		}
+	}
+	return nil
+}
+
+// DefaultPrefix starts the names of devcontainers, their git branches, and
+// jj workspaces unless ListOptions.Prefix and StartOptions.Prefix say
+// otherwise.
+const DefaultPrefix = "devcontainer"
+
+// namePrefix validates a configured name prefix, defaulting to
+// DefaultPrefix. It must be usable in container names and git branches.
+func namePrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return DefaultPrefix, nil
+	}
+	for i, r := range prefix {
+		if !isASCIILetter(r) && !('0' <= r && r <= '9') && (i == 0 || !strings.ContainsRune("._-", r)) {
+			return "", fmt.Errorf("invalid prefix %q: must start with a letter or digit, followed by letters, digits, '.', '_', or '-'", prefix)
+		}
+	}
+	return prefix, nil
+}
+
+// reservedLabelPrefix marks the labels devcontainer sets itself.
+const reservedLabelPrefix = "claude-devcontainer."
+
+// validateLabel checks that a --label value is key=value with a non-empty
+// key outside the reserved claude-devcontainer.* namespace.
+func validateLabel(label string) error {
+	key, _, ok := strings.Cut(label, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("invalid label %q: expected key=value", label)
+	}
+	if strings.HasPrefix(key, reservedLabelPrefix) {
+		return fmt.Errorf("invalid label %q: keys starting with %q are reserved", label, reservedLabelPrefix)
+	}
+	return nil
+}
+
+// readLabelFile reads the labels of a --label-file: one key=value per
+// line, with blank lines and lines starting with # skipped.
+func readLabelFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading --label-file: %w", err)
+	}
+	var labels []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateLabel(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		labels = append(labels, line)
+	}
+	return labels, nil
+}
+
+// validateLabelFilter checks that a --label-filter value is key=value or
+// a bare key.
+func validateLabelFilter(filter string) error {
+	key, _, _ := strings.Cut(filter, "=")
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("invalid label filter %q: expected key=value or key", filter)
+	}
+	return nil
+}
+
+// normalizePorts checks that each port mapping is hostPort:containerPort,
+// either side a port or a range such as 8000-8010 (ranges of equal size),
+// and drops exact duplicates. Host ports claimed by two mappings are
+// rejected, since docker would only fail on them once the container
+// starts; host port 0 (any free port) never conflicts.
+func normalizePorts(ports []string) ([]string, error) {
+	var out []string
+	type claim struct {
+		first, last int
+		mapping     string
+	}
+	var claims []claim
+	for _, p := range ports {
+		host, container, ok := strings.Cut(p, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid port format %q: expected hostPort:containerPort", p)
+		}
+		hostFirst, hostLast, err := parsePortRange(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host port in %q: %w", p, err)
+		}
+		containerFirst, containerLast, err := parsePortRange(container)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container port in %q: %w", p, err)
+		}
+		if hostLast-hostFirst != containerLast-containerFirst {
+			return nil, fmt.Errorf("invalid port mapping %q: host and container ranges differ in size", p)
+		}
+		if slices.Contains(out, p) {
+			continue
+		}
+		if hostFirst != 0 {
+			for _, c := range claims {
+				if hostFirst <= c.last && c.first <= hostLast {
+					return nil, fmt.Errorf("port mappings %q and %q both use host port %d", c.mapping, p, max(hostFirst, c.first))
+				}
+			}
+			claims = append(claims, claim{hostFirst, hostLast, p})
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// parsePortRange parses a port ("8080") or an inclusive range
+// ("8000-8010").
+func parsePortRange(s string) (first, last int, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if first, err = strconv.Atoi(lo); err != nil {
+		return 0, 0, err
+	}
+	last = first
+	if isRange {
+		if last, err = strconv.Atoi(hi); err != nil {
+			return 0, 0, err
+		}
+		if last < first {
+			return 0, 0, fmt.Errorf("range %s is reversed", s)
+		}
+	}
+	if first < 0 || last > 65535 {
+		return 0, 0, fmt.Errorf("port %s is out of range", s)
+	}
+	return first, last, nil
+}
+
+// validateCACert checks that path is a PEM bundle holding at least one
+// certificate.
+func validateCACert(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("invalid --ca-cert %s: no PEM certificate found", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,933 @@
+package devcontainer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// worktree is the isolated VCS checkout a container works in, so the
+// container doesn't modify the caller's working copy.
+type worktree struct {
+	// vcs is "git" or "jj", or "copy" for a plain copy of the workspace
+	// (see StartOptions.CopyOnWrite).
+	vcs string
+	// dir is the host path of the worktree.
+	dir string
+	// original is the workspace the worktree was created from.
+	original string
+	// gitDir overrides the original's git directory, which is otherwise
+	// original/.git (git only).
+	gitDir string
+	// suffix distinguishes this worktree's branch, workspace, and container.
+	suffix string
+	// branch is the git branch checked out in the worktree (git only).
+	branch string
+	// remote and remoteBranch are the remote branch a new worktree branch
+	// tracks, or the remote bookmark a jj workspace is based on, if any.
+	remote, remoteBranch string
+	// jjWorkspace is the name of the jj workspace (jj only).
+	jjWorkspace string
+
+	// keep and branchPolicy control what cleanup removes.
+	keep         bool
+	branchPolicy BranchPolicy
+	// persistent worktrees are never cleaned up, and runs with the same
+	// name reuse them; see persistMarker.
+	persistent bool
+	// containerKept marks a worktree whose container is kept (see
+	// StartOptions.KeepContainer). Its VCS files stay set up for the
+	// container, which couldn't restart otherwise, until 'stop --rm'
+	// removes it; see restoreForHost.
+	containerKept bool
+	// verbose shows the output of cleanup's git and jj commands, which is
+	// otherwise only shown when they fail.
+	verbose bool
+
+	// hostVCSFile holds the original contents of the worktree's .git
+	// gitlink or .jj/repo pointer, which vcsMounts replaces for the
+	// container, so a kept worktree can be restored for use on the host.
+	hostVCSFile []byte
+}
+
+// BranchPolicy controls whether cleanup deletes a git worktree's branch.
+type BranchPolicy string
+
+const (
+	// BranchKeep always keeps the branch, which holds the session's work,
+	// e.g. to open a PR from it or reopen it with the same name. This is
+	// the default.
+	BranchKeep BranchPolicy = ""
+	// BranchDeleteMerged deletes the branch only if it is merged into the
+	// original workspace's HEAD, so unmerged work is never lost.
+	BranchDeleteMerged BranchPolicy = "merged"
+	// BranchDelete deletes the branch even if it is unmerged.
+	BranchDelete BranchPolicy = "delete"
+)
+
+// detectVCS resolves the VCS of workspace: explicit, then $DEVCONTAINER_VCS,
+// then auto-detection from .jj/ or .git/. It returns "" for a workspace
+// without a VCS.
+func detectVCS(explicit, workspace string) (string, error) {
+	vcs := explicit
+	if vcs == "" {
+		vcs = os.Getenv("DEVCONTAINER_VCS")
+	}
+	if vcs == "" {
+		if isDir(filepath.Join(workspace, ".jj")) {
+			vcs = "jj"
+		} else if isDir(filepath.Join(workspace, ".git")) {
+			vcs = "git"
+		}
+	}
+	if vcs != "" && vcs != "git" && vcs != "jj" {
+		return "", fmt.Errorf("unknown VCS type: %s (expected 'git' or 'jj')", vcs)
+	}
+	return vcs, nil
+}
+
+// worktreeOptions configures createWorktree.
+type worktreeOptions struct {
+	vcs       string
+	workspace string
+	// gitDir is the git directory of workspace when it isn't
+	// workspace/.git.
+	gitDir string
+	// prefix starts the worktree directory, branch, and jj workspace
+	// names, followed by "-" and the name or a random suffix.
+	prefix string
+	// name, if set, fixes the suffix so the worktree path (and git
+	// branch) are reused across runs.
+	name string
+	// force prunes stale worktrees and deletes a leftover branch for the
+	// named case.
+	force bool
+	// includeDirty carries the workspace's uncommitted changes into the
+	// worktree instead of starting from the last commit.
+	includeDirty bool
+	// track, as remote/branch, fetches the remote branch and starts the
+	// worktree from it instead of HEAD.
+	track string
+	// nameFromBranch, without name, starts the random suffix with the git
+	// branch checked out in workspace.
+	nameFromBranch bool
+	// persist, with name, marks the worktree persistent. A persistent
+	// worktree of the same name is reused as it is, unless force asks for
+	// it to be recreated.
+	persist bool
+	// assumeYes removes a stale worktree registration at the path without
+	// asking; see confirmReclaim.
+	assumeYes bool
+}
+
+// createWorktree creates a worktree of opts.workspace under
+// worktreeParent.
+func createWorktree(ctx context.Context, opts worktreeOptions) (*worktree, error) {
+	w := &worktree{vcs: opts.vcs, original: opts.workspace, gitDir: opts.gitDir}
+	parent := worktreeParent(opts.vcs, opts.workspace)
+	if opts.name != "" && !opts.force {
+		w.suffix = opts.name
+		w.dir = opts.namedDir()
+		if marker := w.persistMarker(); marker != "" && fileExists(marker) {
+			if opts.includeDirty || opts.track != "" {
+				return nil, fmt.Errorf("cannot combine --include-dirty or --track with reusing persistent worktree %s; pass --force to recreate it", w.dir)
+			}
+			return w.reuse(opts.prefix)
+		}
+	}
+	var hint string
+	if opts.track != "" {
+		remote, branch, ok := strings.Cut(opts.track, "/")
+		if !ok || remote == "" || branch == "" {
+			return nil, fmt.Errorf("invalid --track %q: expected <remote>/<branch>", opts.track)
+		}
+		w.remote, w.remoteBranch = remote, branch
+		// Before anything is created, so a typo leaves nothing behind
+		if err := w.fetchTrack(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if opts.name != "" {
+		w.suffix = opts.name
+		w.dir = opts.namedDir()
+		// Remove existing directory if present
+		os.RemoveAll(w.dir)
+		if err := os.MkdirAll(filepath.Dir(w.dir), 0755); err != nil {
+			return nil, fmt.Errorf("creating worktree dir: %w", err)
+		}
+	} else {
+		if opts.nameFromBranch && opts.vcs == "git" {
+			hint = w.branchHint(ctx, opts.prefix)
+		}
+		var err error
+		w.dir, w.suffix, err = randomWorktreeDir(parent, opts.prefix, hint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch opts.vcs {
+	case "git":
+		w.branch = opts.prefix + "-" + w.suffix
+		if opts.force && opts.name != "" {
+			// Drop worktree metadata left by a crashed run, then the
+			// stale branch itself, so the worktree starts fresh.
+			runCmd(ctx, "git", w.git("worktree", "prune")...)
+			if execCommand(ctx, "git", w.git("rev-parse", "--verify", w.branch)...).Run() == nil {
+				fmt.Fprintf(os.Stderr, "devcontainer: deleting stale branch %s (--force)\n", w.branch)
+				if err := runCmd(ctx, "git", w.git("branch", "-D", w.branch)...); err != nil {
+					return nil, fmt.Errorf("deleting stale branch %s: %w", w.branch, err)
+				}
+			}
+		}
+		reclaimed := false
+		for attempt := 1; ; attempt++ {
+			err := w.addGitWorktree(ctx)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, errStaleWorktree) && !reclaimed {
+				// The path is ours (it has our prefix), so a registration
+				// left by a crashed run can be dropped without touching
+				// any other worktree
+				fmt.Fprintf(os.Stderr, "devcontainer: %s is still registered as a (possibly locked) worktree by an earlier run\n", w.dir)
+				if err := confirmReclaim(w.dir, opts.assumeYes); err != nil {
+					return nil, err
+				}
+				if err := runCmd(ctx, "git", w.git("worktree", "remove", "-f", "-f", w.dir)...); err != nil {
+					return nil, fmt.Errorf("removing stale worktree %s: %w", w.dir, err)
+				}
+				reclaimed = true
+				continue
+			}
+			if !errors.Is(err, errBranchInUse) {
+				return nil, fmt.Errorf("creating git worktree: %w", err)
+			}
+			if opts.name != "" {
+				return nil, fmt.Errorf("creating git worktree: branch %s is already in use, likely left over from a crashed run; rerun with --force to remove the stale branch first", w.branch)
+			}
+			if attempt == 3 {
+				return nil, fmt.Errorf("creating git worktree: %w", err)
+			}
+			// A random suffix collided with a leftover branch; pick another,
+			// without the branch name in case that's what collides
+			hint = ""
+			w.dir, w.suffix, err = randomWorktreeDir(parent, opts.prefix, hint)
+			if err != nil {
+				return nil, err
+			}
+			w.branch = opts.prefix + "-" + w.suffix
+			fmt.Fprintf(os.Stderr, "devcontainer: retrying with branch %s\n", w.branch)
+		}
+		if opts.includeDirty {
+			if err := w.applyDirty(ctx); err != nil {
+				w.cleanup(ctx)
+				return nil, err
+			}
+		}
+	case "copy":
+		if err := copyWorkspace(ctx, opts.workspace, w.dir); err != nil {
+			os.RemoveAll(w.dir)
+			return nil, err
+		}
+	case "jj":
+		w.jjWorkspace = opts.prefix + "-" + w.suffix
+		if opts.force && opts.name != "" {
+			// A workspace of this name left by a crashed run, or a
+			// persistent one being recreated, is still registered
+			execCommand(ctx, "jj", "-R", opts.workspace, "workspace", "forget", w.jjWorkspace).Run()
+		}
+		args := []string{"-R", opts.workspace, "workspace", "add", "--name", w.jjWorkspace}
+		if w.remote != "" {
+			args = append(args, "--revision", w.remoteBookmark())
+		} else if opts.includeDirty {
+			// The working copy is a commit, so basing the new workspace on
+			// it (rather than on its parents, the default) carries the
+			// in-progress changes over.
+			args = append(args, "--revision", "@")
+		}
+		args = append(args, w.dir)
+		if err := runCmd(ctx, "jj", args...); err != nil {
+			return nil, fmt.Errorf("creating jj workspace: %w", err)
+		}
+	}
+	if opts.persist && opts.name != "" {
+		w.persistent = true
+		if err := os.WriteFile(w.persistMarker(), nil, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: marking worktree %s persistent: %v\n", w.dir, err)
+		}
+	}
+	return w, nil
+}
+
+// persistMarkerName is the file marking a worktree persistent: in git's
+// administrative directory of the worktree, or in its .jj, so the VCS
+// ignores it and the container can't commit it.
+const persistMarkerName = "devcontainer-persistent"
+
+// persistMarker returns the path of the worktree's persistMarkerName, or ""
+// if it has no place for one (a plain copy, or a git worktree that isn't
+// there).
+func (w *worktree) persistMarker() string {
+	switch w.vcs {
+	case "git":
+		data := w.hostVCSFile
+		if data == nil {
+			var err error
+			if data, err = os.ReadFile(filepath.Join(w.dir, ".git")); err != nil {
+				return ""
+			}
+		}
+		gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return ""
+		}
+		// A run that didn't get to clean up leaves the gitlink rewritten
+		// for the container
+		return filepath.Join(strings.Replace(gitdir, dotGitMount, w.hostGitDir(), 1), persistMarkerName)
+	case "jj":
+		return filepath.Join(w.dir, ".jj", persistMarkerName)
+	}
+	return ""
+}
+
+// reuse takes over the persistent worktree at w.dir as it is, first
+// repairing the VCS file a run that didn't get to clean up left set up for
+// the container.
+func (w *worktree) reuse(prefix string) (*worktree, error) {
+	fmt.Fprintf(os.Stderr, "devcontainer: reusing persistent worktree %s\n", w.dir)
+	w.persistent = true
+	switch w.vcs {
+	case "git":
+		w.branch = prefix + "-" + w.suffix
+		gitlink := filepath.Join(w.dir, ".git")
+		if data, err := os.ReadFile(gitlink); err == nil && strings.Contains(string(data), dotGitMount) {
+			if err := os.WriteFile(gitlink, []byte(strings.Replace(string(data), dotGitMount, w.hostGitDir(), 1)), 0644); err != nil {
+				return nil, fmt.Errorf("restoring gitlink of persistent worktree %s: %w", w.dir, err)
+			}
+		}
+	case "jj":
+		w.jjWorkspace = prefix + "-" + w.suffix
+		// vcsMounts removed the .jj/repo file, and docker may have left an
+		// empty mount point directory in its place
+		repo := filepath.Join(w.dir, ".jj", "repo")
+		if info, err := os.Stat(repo); err != nil || info.IsDir() {
+			os.Remove(repo)
+			if err := os.WriteFile(repo, []byte(jjRepoDir(w.original)), 0644); err != nil {
+				return nil, fmt.Errorf("restoring .jj/repo of persistent workspace %s: %w", w.dir, err)
+			}
+		}
+	}
+	return w, nil
+}
+
+// copyWorkspace copies workspace to dir, sharing blocks through reflinks
+// where the filesystem supports them. GNU cp falls back to a full copy by
+// itself; macOS cp clones only on APFS, so a failed clone is retried as a
+// full copy.
+func copyWorkspace(ctx context.Context, workspace, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating workspace copy: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "devcontainer: copying workspace to %s\n", dir)
+	// The trailing /. copies the contents, including dotfiles, into dir
+	src := workspace + "/."
+	if runtime.GOOS != "darwin" {
+		if err := runCmd(ctx, "cp", "-a", "--reflink=auto", src, dir); err != nil {
+			return fmt.Errorf("copying workspace: %w", err)
+		}
+		return nil
+	}
+	if execCommand(ctx, "cp", "-ac", src, dir).Run() == nil {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "devcontainer: the filesystem can't clone files; making a full copy")
+	if err := runCmd(ctx, "cp", "-a", src, dir); err != nil {
+		return fmt.Errorf("copying workspace: %w", err)
+	}
+	return nil
+}
+
+// applyDirty copies the original workspace's uncommitted changes into the
+// git worktree: tracked changes through a stash commit, which leaves the
+// original's working tree and stash list untouched, and untracked files
+// that aren't ignored by copying them.
+func (w *worktree) applyDirty(ctx context.Context) error {
+	out, err := execCommand(ctx, "git", w.git("stash", "create")...).Output()
+	if err != nil {
+		return fmt.Errorf("stashing uncommitted changes: %w", err)
+	}
+	if stash := strings.TrimSpace(string(out)); stash != "" {
+		if err := runCmd(ctx, "git", "-C", w.dir, "stash", "apply", stash); err != nil {
+			return fmt.Errorf("applying uncommitted changes to the worktree: %w", err)
+		}
+	}
+
+	out, err = execCommand(ctx, "git", w.git("ls-files", "--others", "--exclude-standard", "-z")...).Output()
+	if err != nil {
+		return fmt.Errorf("listing untracked files: %w", err)
+	}
+	for _, rel := range strings.Split(string(out), "\x00") {
+		if rel == "" {
+			continue
+		}
+		if err := copyPath(filepath.Join(w.original, rel), filepath.Join(w.dir, rel)); err != nil {
+			return fmt.Errorf("copying untracked file %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// copyPath copies the file or symlink at src to dst, creating dst's parent
+// directories and preserving the file mode.
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// worktreeParent returns the directory the worktrees of workspace with vcs
+// are created in: the temp dir, or for a copy, the workspace's parent. The
+// copy can only share blocks with the workspace (see copyWorkspace) on the
+// same filesystem, and the temp dir is often a tmpfs; if the parent isn't
+// writable, the copy goes to the temp dir after all.
+func worktreeParent(vcs, workspace string) string {
+	if vcs == "copy" {
+		if parent := filepath.Dir(workspace); syscall.Access(parent, wOK) == nil {
+			return parent
+		}
+	}
+	return os.TempDir()
+}
+
+// persistentWorktreeParent returns the directory persistent worktrees are
+// created in. Unlike the temp dir, it survives reboots and tmp cleaners.
+func persistentWorktreeParent() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home dir: %w", err)
+	}
+	return filepath.Join(xdgDir("XDG_DATA_HOME", homeDir, ".local/share"), "claude-devcontainer", "worktrees"), nil
+}
+
+// namedDir returns the path of the worktree named opts.name: in
+// persistentWorktreeParent if it is to be made persistent or a persistent
+// one of that name is there, and in worktreeParent otherwise.
+func (opts worktreeOptions) namedDir() string {
+	base := opts.prefix + "-" + opts.name
+	if parent, err := persistentWorktreeParent(); err == nil && opts.vcs != "copy" {
+		w := &worktree{vcs: opts.vcs, original: opts.workspace, gitDir: opts.gitDir, dir: filepath.Join(parent, base)}
+		if opts.persist || fileExists(w.persistMarker()) {
+			return w.dir
+		}
+	}
+	return filepath.Join(worktreeParent(opts.vcs, opts.workspace), base)
+}
+
+// wOK is access(2)'s W_OK, which package syscall doesn't define.
+const wOK = 0x2
+
+// randomWorktreeDir picks a fresh path in parent for a worktree, named
+// prefix- and a random suffix, and returns it along with the suffix. A
+// non-empty hint starts the suffix, as in prefix-hint-random. The
+// directory itself is removed again so the VCS can create it.
+func randomWorktreeDir(parent, prefix, hint string) (dir, suffix string, err error) {
+	base := prefix + "-"
+	if hint != "" {
+		base += hint + "-"
+	}
+	dir, err = os.MkdirTemp(parent, base)
+	if err != nil {
+		return "", "", fmt.Errorf("creating temp dir: %w", err)
+	}
+	os.Remove(dir)
+	// The base name already starts with the prefix, strip it for the suffix
+	return dir, strings.TrimPrefix(filepath.Base(dir), prefix+"-"), nil
+}
+
+// maxBranchHint caps the part of a worktree name taken from the branch,
+// keeping container names readable in list output.
+const maxBranchHint = 32
+
+// branchHint returns the branch checked out in the original workspace,
+// sanitized for a container name, to start a random suffix with. It
+// returns "" on a detached HEAD, for a branch with nothing usable in its
+// name, and for a devcontainer branch itself (one starting with prefix-,
+// as in a worktree of a worktree), whose name would only nest.
+func (w *worktree) branchHint(ctx context.Context, prefix string) string {
+	out, err := execCommand(ctx, "git", w.git("symbolic-ref", "--short", "-q", "HEAD")...).Output()
+	if err != nil {
+		return ""
+	}
+	branch := strings.TrimSpace(string(out))
+	if strings.HasPrefix(branch, prefix+"-") {
+		return ""
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(branch) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			// Slashes, dots, and the like; runs collapse into one dash
+			dash = true
+		}
+	}
+	hint := b.String()
+	if len(hint) > maxBranchHint {
+		hint = strings.TrimRight(hint[:maxBranchHint], "-")
+	}
+	return hint
+}
+
+// confirmReclaim asks before removing the stale worktree registration of
+// dir, which may be locked to keep it. Without a terminal to ask on,
+// assumeYes is required.
+func confirmReclaim(dir string, assumeYes bool) error {
+	if assumeYes {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("refusing to remove the stale worktree registration of %s without confirmation; pass --yes, or remove it with 'git worktree remove -f -f %s'", dir, dir)
+	}
+	prompt := promptui.Prompt{
+		Label:     "Remove its registration and reuse the path",
+		IsConfirm: true,
+		Stdout:    os.Stderr,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return fmt.Errorf("aborted: removing the stale worktree registration of %s not confirmed (pass --yes to skip this prompt)", dir)
+	}
+	return nil
+}
+
+// errStaleWorktree means git refused to create a worktree because its path
+// is still registered, and possibly locked, by a worktree whose directory
+// is gone.
+var errStaleWorktree = errors.New("worktree path already registered")
+
+// errBranchInUse means git refused to create a worktree because the branch
+// already exists or is checked out by another (possibly stale) worktree.
+var errBranchInUse = errors.New("branch already in use")
+
+// git returns the arguments running git subcommand args against the
+// original repository.
+func (w *worktree) git(args ...string) []string {
+	repo := []string{"-C", w.original}
+	if w.gitDir != "" {
+		// With --git-dir, git takes the working directory (set by -C) as
+		// the top of the work tree
+		repo = append(repo, "--git-dir", w.gitDir)
+	}
+	return append(repo, args...)
+}
+
+// fetchTrack fetches the remote branch the worktree is to start from and
+// checks that it exists.
+func (w *worktree) fetchTrack(ctx context.Context) error {
+	track := w.remote + "/" + w.remoteBranch
+	fmt.Fprintf(os.Stderr, "devcontainer: fetching %s\n", track)
+	switch w.vcs {
+	case "git":
+		if err := runCmd(ctx, "git", w.git("fetch", w.remote, w.remoteBranch)...); err != nil {
+			return fmt.Errorf("fetching %s: %w", track, err)
+		}
+		if execCommand(ctx, "git", w.git("rev-parse", "--verify", "--quiet", "refs/remotes/"+track)...).Run() != nil {
+			return fmt.Errorf("remote branch %s not found after fetching", track)
+		}
+	case "jj":
+		if err := runCmd(ctx, "jj", "-R", w.original, "git", "fetch", "--remote", w.remote, "--branch", w.remoteBranch); err != nil {
+			return fmt.Errorf("fetching %s: %w", track, err)
+		}
+		if execCommand(ctx, "jj", "-R", w.original, "log", "--no-graph", "-r", w.remoteBookmark(), "-T", "commit_id").Run() != nil {
+			return fmt.Errorf("remote bookmark %s@%s not found after fetching", w.remoteBranch, w.remote)
+		}
+	default:
+		return fmt.Errorf("--track needs a git or jj workspace")
+	}
+	return nil
+}
+
+// remoteBookmark is the jj revset of the tracked remote bookmark, quoted
+// since branch names like feature/x aren't plain symbols.
+func (w *worktree) remoteBookmark() string {
+	return strconv.Quote(w.remoteBranch) + "@" + strconv.Quote(w.remote)
+}
+
+// addGitWorktree creates the git worktree at w.dir for w.branch, creating
+// the branch from HEAD, or tracking the remote branch of --track, unless it
+// already exists (e.g. from a previous run whose worktree was cleaned up
+// but the branch was kept).
+func (w *worktree) addGitWorktree(ctx context.Context) error {
+	args := w.git("worktree", "add")
+	if execCommand(ctx, "git", w.git("rev-parse", "--verify", w.branch)...).Run() == nil {
+		fmt.Fprintf(os.Stderr, "devcontainer: reopening existing branch %s\n", w.branch)
+		args = append(args, w.dir, w.branch)
+	} else if w.remote != "" {
+		args = append(args, "--track", "-b", w.branch, w.dir, w.remote+"/"+w.remoteBranch)
+	} else {
+		args = append(args, "-b", w.branch, w.dir)
+	}
+
+	var stderr bytes.Buffer
+	cmd := execCommand(ctx, "git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		msg := stderr.String()
+		if strings.Contains(msg, "is a missing but locked worktree") ||
+			strings.Contains(msg, "is a missing but already registered worktree") {
+			return fmt.Errorf("%w: %s", errStaleWorktree, strings.TrimSpace(msg))
+		}
+		if strings.Contains(msg, "a branch named") ||
+			strings.Contains(msg, "is already checked out") ||
+			strings.Contains(msg, "is already used by worktree") {
+			return fmt.Errorf("%w: %s", errBranchInUse, strings.TrimSpace(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// hostGitDir is the original repository's git directory on the host.
+func (w *worktree) hostGitDir() string {
+	if w.gitDir != "" {
+		return w.gitDir
+	}
+	return filepath.Join(w.original, ".git")
+}
+
+// vcsMounts prepares the worktree for use inside the container, where it
+// appears at containerWorkspace, and returns the mounts of the original
+// repository's VCS data it needs.
+func (w *worktree) vcsMounts(containerWorkspace string) []mount {
+	switch w.vcs {
+	case "git":
+		// Mount the original .git at a non-conflicting path. We can't
+		// mount it at containerWorkspace/.git because the worktree has
+		// a .git gitlink file there (Docker can't mount a directory
+		// over a file). Rewrite the gitlink to point to the mounted
+		// path so git preserves the worktree identity and uses the
+		// worktree's own index/HEAD instead of the main ones.
+		gitlinkPath := filepath.Join(w.dir, ".git")
+		if data, err := os.ReadFile(gitlinkPath); err == nil {
+			w.hostVCSFile = data
+			gitdir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir: "))
+			newGitdir := strings.Replace(gitdir, w.hostGitDir(), dotGitMount, 1)
+			os.WriteFile(gitlinkPath, []byte("gitdir: "+newGitdir+"\n"), 0644)
+		}
+	case "jj":
+		// The workspace contains a .jj/repo file (pointer to the
+		// original repo), but we need to bind-mount the original
+		// .jj/repo directory over it. Remove the file first.
+		if data, err := os.ReadFile(filepath.Join(w.dir, ".jj", "repo")); err == nil {
+			w.hostVCSFile = data
+		}
+		os.Remove(filepath.Join(w.dir, ".jj", "repo"))
+	}
+	return w.repoMounts(containerWorkspace)
+}
+
+// dotGitMount is where the original .git of a git worktree is mounted.
+const dotGitMount = "/.devcontainer-git"
+
+// repoMounts returns the mounts of vcsMounts without preparing the
+// worktree, which need not exist yet.
+func (w *worktree) repoMounts(containerWorkspace string) []mount {
+	switch w.vcs {
+	case "git":
+		return []mount{{src: w.hostGitDir(), dst: dotGitMount}}
+	case "jj":
+		// If the original is itself a secondary workspace, its .jj/repo
+		// is a pointer too, so resolve the primary repo directory.
+		jjRepo := jjRepoDir(w.original)
+		mounts := []mount{{src: jjRepo, dst: containerWorkspace + "/.jj/repo"}}
+		// If jj uses a git backend, also mount the git repo it points to.
+		gitTargetFile := filepath.Join(jjRepo, "store", "git_target")
+		if data, err := os.ReadFile(gitTargetFile); err == nil {
+			target := strings.TrimSpace(string(data))
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(jjRepo, "store", target)
+			}
+			target = filepath.Clean(target)
+			// Only mount if not already under .jj/repo (which is already mounted)
+			if !strings.HasPrefix(target, jjRepo+string(filepath.Separator)) && target != jjRepo {
+				if isDir(target) {
+					mounts = append(mounts, mount{src: target, dst: target})
+				}
+			}
+		}
+		return mounts
+	}
+	return nil
+}
+
+// cleanup removes the worktree, or with keep restores it for use on the
+// host, and applies the branch policy. It is a no-op for a nil worktree.
+// Cleanup still runs after ctx is cancelled, so an interrupted session
+// doesn't leave the worktree behind. If the original workspace was moved or
+// deleted in the meantime, only the worktree directory is reclaimed and a
+// warning explains how to drop the VCS metadata left behind. SIGINT and
+// SIGTERM are held off meanwhile so a second Ctrl-C can't leave the
+// worktree half-removed.
+func (w *worktree) cleanup(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if w.containerKept {
+		fmt.Fprintf(os.Stderr, "devcontainer: kept worktree %s for the kept container; it works on the host again once 'stop --rm' removes the container\n", w.dir)
+		return
+	}
+	if w.keep || w.persistent {
+		w.restore()
+		return
+	}
+	// Like git branch -d, keep jj work that isn't anywhere else unless
+	// told to delete it
+	if w.vcs == "jj" && w.branchPolicy != BranchDelete && isDir(w.original) {
+		changes, err := w.unmergedJJChanges(ctx)
+		if err != nil {
+			w.restore()
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: kept jj workspace %s: checking it for unmerged changes: %v\n", w.jjWorkspace, err)
+			return
+		}
+		if len(changes) > 0 {
+			w.restore()
+			fmt.Fprintf(os.Stderr, "devcontainer: kept jj workspace %s: it has changes (%s) not in trunk() or on a remote bookmark; once done, run 'jj workspace forget %s' and remove the directory\n", w.jjWorkspace, strings.Join(changes, ", "), w.jjWorkspace)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "devcontainer: removing worktree %s; please don't interrupt\n", w.dir)
+	defer holdSignals()()
+	originalGone := !isDir(w.original)
+	if originalGone {
+		fmt.Fprintf(os.Stderr, "devcontainer: warning: original workspace %s no longer exists\n", w.original)
+	}
+	switch w.vcs {
+	case "git":
+		// The .git gitlink file is rewritten to point into the container
+		// before it starts, so "git worktree remove" would fail. Instead,
+		// delete the directory and prune stale worktree metadata.
+		os.RemoveAll(w.dir)
+		if originalGone {
+			fmt.Fprintf(os.Stderr, "devcontainer: removed %s; run 'git worktree prune' in the repository's new location to drop its metadata\n", w.dir)
+			return
+		}
+		if err := w.runCleanupCmd(ctx, "git", w.git("worktree", "prune")...); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: git worktree prune: %v\n", err)
+		}
+		w.deleteBranch(ctx)
+	case "jj":
+		if originalGone {
+			os.RemoveAll(w.dir)
+			fmt.Fprintf(os.Stderr, "devcontainer: removed %s; run 'jj workspace forget %s' in the repository's new location\n", w.dir, w.jjWorkspace)
+			return
+		}
+		// Forget via the workspace that owns .jj/repo, which may differ
+		// from the original when that is a secondary workspace.
+		repoRoot := filepath.Dir(filepath.Dir(jjRepoDir(w.original)))
+		if err := w.runCleanupCmd(ctx, "jj", "-R", repoRoot, "workspace", "forget", w.jjWorkspace); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: jj workspace forget %s: %v\n", w.jjWorkspace, err)
+		}
+		os.RemoveAll(w.dir)
+	case "copy":
+		os.RemoveAll(w.dir)
+	}
+}
+
+// holdSignals catches SIGINT and SIGTERM, noting that cleanup is still in
+// progress instead of letting them stop it, until the returned function is
+// called.
+func holdSignals() (release func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range sigCh {
+			fmt.Fprintln(os.Stderr, "devcontainer: still cleaning up the worktree; please wait")
+		}
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}
+}
+
+// cleanupCommand is execCommand for cleanup steps. The command runs in its
+// own process group, so a Ctrl-C at the terminal doesn't reach it.
+func cleanupCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := execCommand(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// runCleanupCmd is runCmd for cleanup steps; see cleanupCommand. Unless
+// w.verbose is set, the command's output is held back and only written to
+// stderr if it fails, so it doesn't trail the session's own output.
+func (w *worktree) runCleanupCmd(ctx context.Context, name string, args ...string) error {
+	cmd := cleanupCommand(ctx, name, args...)
+	if w.verbose {
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err != nil {
+		os.Stderr.Write(out.Bytes())
+	}
+	return err
+}
+
+// unmergedJJRevset selects the non-empty commits of a jj workspace's
+// working copy and its ancestors that no other workspace, trunk(), or remote
+// bookmark contains, i.e. work that forgetting the workspace would leave
+// only in an anonymous head.
+const unmergedJJRevset = "(::@ ~ ::(trunk() | remote_bookmarks() | (working_copies() ~ @))) ~ empty()"
+
+// unmergedJJChanges returns the short change IDs of unmergedJJRevset in the
+// jj workspace. Running jj in the workspace first snapshots edits made in
+// the container, so they count too.
+func (w *worktree) unmergedJJChanges(ctx context.Context) ([]string, error) {
+	if w.hostVCSFile != nil {
+		// jj needs the .jj/repo pointer vcsMounts replaced
+		if err := os.WriteFile(filepath.Join(w.dir, ".jj", "repo"), w.hostVCSFile, 0644); err != nil {
+			return nil, err
+		}
+	}
+	out, err := cleanupCommand(ctx, "jj", "-R", w.dir, "log", "--no-graph", "-r", unmergedJJRevset, "-T", `change_id.short() ++ "\n"`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("jj log: %w", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// restore puts back the VCS file vcsMounts replaced, so the kept worktree
+// works on the host again.
+func (w *worktree) restore() {
+	if w.hostVCSFile != nil {
+		switch w.vcs {
+		case "git":
+			os.WriteFile(filepath.Join(w.dir, ".git"), w.hostVCSFile, 0644)
+		case "jj":
+			os.WriteFile(filepath.Join(w.dir, ".jj", "repo"), w.hostVCSFile, 0644)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "devcontainer: kept worktree %s\n", w.dir)
+}
+
+// restoreForHost puts back the VCS file of the worktree a removed container
+// mounted, found by the mounts docker inspect reported for it, so a
+// worktree kept along with its container works on the host again. It is
+// restore for a later process, which no longer has the original file: the
+// gitlink is rewritten to the git directory mounted at dotGitMount, and
+// .jj/repo points at the jj repository mounted over it. It returns the
+// worktree's path, or "" if the container mounted none.
+func restoreForHost(mounts []inspectedMount) (string, error) {
+	for _, m := range mounts {
+		if m.Destination == dotGitMount {
+			for _, ws := range mounts {
+				gitlink := filepath.Join(ws.Source, ".git")
+				data, err := os.ReadFile(gitlink)
+				if err != nil || !strings.HasPrefix(string(data), "gitdir: "+dotGitMount) {
+					continue
+				}
+				return ws.Source, os.WriteFile(gitlink, []byte(strings.Replace(string(data), dotGitMount, m.Source, 1)), 0644)
+			}
+		}
+		if dst, ok := strings.CutSuffix(m.Destination, "/.jj/repo"); ok {
+			for _, ws := range mounts {
+				if ws.Destination != dst {
+					continue
+				}
+				// Drop the empty mount point docker left in place of
+				// the file
+				repo := filepath.Join(ws.Source, ".jj", "repo")
+				os.Remove(repo)
+				return ws.Source, os.WriteFile(repo, []byte(m.Source), 0644)
+			}
+		}
+	}
+	return "", nil
+}
+
+// deleteBranch deletes the worktree's git branch according to its branch
+// policy. The worktree must already be removed.
+func (w *worktree) deleteBranch(ctx context.Context) {
+	switch w.branchPolicy {
+	case BranchDelete:
+		if err := w.runCleanupCmd(ctx, "git", w.git("branch", "-D", w.branch)...); err != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: warning: deleting branch %s: %v\n", w.branch, err)
+		}
+	case BranchDeleteMerged:
+		// git branch -d refuses to delete a branch that isn't merged
+		// into HEAD, which is exactly the check we want.
+		if cleanupCommand(ctx, "git", w.git("branch", "-d", w.branch)...).Run() != nil {
+			fmt.Fprintf(os.Stderr, "devcontainer: kept branch %s: it has unmerged changes\n", w.branch)
+		}
+	}
+}
+
+// jjRepoDir returns the .jj/repo directory backing the jj workspace at
+// workspace. In a secondary workspace .jj/repo is a file holding the path
+// (relative to .jj) of the primary workspace's repo directory.
+func jjRepoDir(workspace string) string {
+	repo := filepath.Join(workspace, ".jj", "repo")
+	if isDir(repo) {
+		return repo
+	}
+	data, err := os.ReadFile(repo)
+	if err != nil {
+		return repo
+	}
+	target := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(workspace, ".jj", target)
+	}
+	return filepath.Clean(target)
+}
@@ -0,0 +1,650 @@
+package devcontainer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDetectVCS(t *testing.T) {
+	both := t.TempDir()
+	os.Mkdir(filepath.Join(both, ".jj"), 0755)
+	os.Mkdir(filepath.Join(both, ".git"), 0755)
+	gitOnly := t.TempDir()
+	os.Mkdir(filepath.Join(gitOnly, ".git"), 0755)
+	none := t.TempDir()
+
+	tests := []struct {
+		name      string
+		explicit  string
+		env       string
+		workspace string
+		want      string
+		wantErr   bool
+	}{
+		{"jj wins over git", "", "", both, "jj", false},
+		{"git", "", "", gitOnly, "git", false},
+		{"no VCS", "", "", none, "", false},
+		{"env overrides detection", "", "git", both, "git", false},
+		{"explicit overrides env", "jj", "git", gitOnly, "jj", false},
+		{"unknown explicit", "hg", "", gitOnly, "", true},
+		{"unknown env", "", "svn", gitOnly, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEVCONTAINER_VCS", tt.env)
+			got, err := detectVCS(tt.explicit, tt.workspace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectVCS error = %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("detectVCS = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitVCSMounts(t *testing.T) {
+	original := t.TempDir()
+	dir := t.TempDir()
+	gitlink := filepath.Join(dir, ".git")
+	os.WriteFile(gitlink, []byte("gitdir: "+original+"/.git/worktrees/wt\n"), 0644)
+
+	w := &worktree{vcs: "git", dir: dir, original: original}
+	got := w.vcsMounts(original)
+	want := []mount{{src: filepath.Join(original, ".git"), dst: "/.devcontainer-git"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("vcsMounts = %+v, want %+v", got, want)
+	}
+	data, _ := os.ReadFile(gitlink)
+	if string(data) != "gitdir: /.devcontainer-git/worktrees/wt\n" {
+		t.Errorf("gitlink = %q, want it rewritten to the mounted .git", data)
+	}
+}
+
+func TestJJVCSMounts(t *testing.T) {
+	// The original is a secondary workspace whose .jj/repo points at the
+	// primary, which is backed by an external git repo.
+	primary := t.TempDir()
+	gitRepo := t.TempDir()
+	os.MkdirAll(filepath.Join(primary, ".jj", "repo", "store"), 0755)
+	os.WriteFile(filepath.Join(primary, ".jj", "repo", "store", "git_target"), []byte(gitRepo), 0644)
+
+	original := t.TempDir()
+	os.Mkdir(filepath.Join(original, ".jj"), 0755)
+	rel, _ := filepath.Rel(filepath.Join(original, ".jj"), filepath.Join(primary, ".jj", "repo"))
+	os.WriteFile(filepath.Join(original, ".jj", "repo"), []byte(rel), 0644)
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, ".jj"), 0755)
+	os.WriteFile(filepath.Join(dir, ".jj", "repo"), []byte("pointer"), 0644)
+
+	w := &worktree{vcs: "jj", dir: dir, original: original}
+	got := w.vcsMounts("/src/repo")
+	want := []mount{
+		{src: filepath.Join(primary, ".jj", "repo"), dst: "/src/repo/.jj/repo"},
+		{src: gitRepo, dst: gitRepo},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("vcsMounts = %+v, want %+v", got, want)
+	}
+	if fileExists(filepath.Join(dir, ".jj", "repo")) {
+		t.Error(".jj/repo pointer was not removed from the worktree")
+	}
+}
+
+func TestCleanupGit(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "devcontainer-abc")
+	os.Mkdir(dir, 0755)
+
+	w := &worktree{vcs: "git", dir: dir, original: original, suffix: "abc", branch: "devcontainer-abc"}
+	w.cleanup(context.Background())
+
+	if isDir(dir) {
+		t.Error("worktree dir was not removed")
+	}
+	if !f.ran("git", "-C", original, "worktree", "prune") {
+		t.Errorf("worktree metadata was not pruned; ran:\n%s", f)
+	}
+	// The branch holds the session's work, so it is kept.
+	if f.ran("git", "-C", original, "branch") {
+		t.Errorf("branch was deleted; ran:\n%s", f)
+	}
+}
+
+func TestGitDirOverride(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	gitDir := t.TempDir()
+	dir := t.TempDir()
+	gitlink := filepath.Join(dir, ".git")
+	os.WriteFile(gitlink, []byte("gitdir: "+gitDir+"/worktrees/wt\n"), 0644)
+
+	w := &worktree{vcs: "git", dir: dir, original: original, gitDir: gitDir, branch: "devcontainer-abc", branchPolicy: BranchDeleteMerged}
+	got := w.vcsMounts(original)
+	if want := []mount{{src: gitDir, dst: "/.devcontainer-git"}}; !slices.Equal(got, want) {
+		t.Errorf("vcsMounts = %+v, want %+v", got, want)
+	}
+	if data, _ := os.ReadFile(gitlink); string(data) != "gitdir: /.devcontainer-git/worktrees/wt\n" {
+		t.Errorf("gitlink = %q, want it rewritten to the mounted git dir", data)
+	}
+
+	w.cleanup(context.Background())
+	if !f.ran("git", "-C", original, "--git-dir", gitDir, "worktree", "prune") ||
+		!f.ran("git", "-C", original, "--git-dir", gitDir, "branch", "-d", "devcontainer-abc") {
+		t.Errorf("--git-dir not passed to git; ran:\n%s", f)
+	}
+}
+
+func TestCleanupBranchPolicy(t *testing.T) {
+	tests := []struct {
+		policy BranchPolicy
+		want   []string
+	}{
+		{BranchKeep, nil},
+		// Only if merged, which git branch -d checks
+		{BranchDeleteMerged, []string{"branch", "-d", "devcontainer-abc"}},
+		{BranchDelete, []string{"branch", "-D", "devcontainer-abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			f := newFakeExec(t, nil)
+			original := t.TempDir()
+			w := &worktree{vcs: "git", dir: filepath.Join(t.TempDir(), "wt"), original: original, branch: "devcontainer-abc", branchPolicy: tt.policy}
+			w.cleanup(context.Background())
+
+			branchCmd := f.find("branch")
+			if tt.want == nil && branchCmd != nil {
+				t.Errorf("branch was deleted: %q", branchCmd)
+			}
+			if tt.want != nil && !f.ran(append([]string{"git", "-C", original}, tt.want...)...) {
+				t.Errorf("want %q; ran:\n%s", tt.want, f)
+			}
+		})
+	}
+}
+
+func TestCleanupKeepWorktree(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	dir := t.TempDir()
+	gitlink := filepath.Join(dir, ".git")
+	hostGitlink := "gitdir: " + original + "/.git/worktrees/wt\n"
+	os.WriteFile(gitlink, []byte(hostGitlink), 0644)
+
+	w := &worktree{vcs: "git", dir: dir, original: original, branch: "devcontainer-abc", keep: true}
+	w.vcsMounts(original)
+	w.cleanup(context.Background())
+
+	if data, _ := os.ReadFile(gitlink); string(data) != hostGitlink {
+		t.Errorf("gitlink = %q, want it restored to %q", data, hostGitlink)
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("kept worktree ran commands:\n%s", f)
+	}
+}
+
+func TestCleanupJJ(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	os.MkdirAll(filepath.Join(original, ".jj", "repo"), 0755)
+	dir := filepath.Join(t.TempDir(), "devcontainer-abc")
+	os.Mkdir(dir, 0755)
+
+	w := &worktree{vcs: "jj", dir: dir, original: original, suffix: "abc", jjWorkspace: "devcontainer-abc"}
+	w.cleanup(context.Background())
+
+	if isDir(dir) {
+		t.Error("workspace dir was not removed")
+	}
+	if !f.ran("jj", "-R", original, "workspace", "forget", "devcontainer-abc") {
+		t.Errorf("workspace was not forgotten; ran:\n%s", f)
+	}
+}
+
+func TestCleanupJJUnmerged(t *testing.T) {
+	newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "log") {
+			return "kxqpzmlo\n", 0
+		}
+		return "", 0
+	})
+	original := t.TempDir()
+	os.MkdirAll(filepath.Join(original, ".jj", "repo"), 0755)
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".jj"), 0755)
+	pointer := filepath.Join(dir, ".jj", "repo")
+	os.WriteFile(pointer, []byte("../../original/.jj/repo"), 0644)
+
+	w := &worktree{vcs: "jj", dir: dir, original: original, jjWorkspace: "devcontainer-abc"}
+	w.vcsMounts(original)
+	w.cleanup(context.Background())
+	if !isDir(dir) {
+		t.Fatal("workspace with unmerged changes was removed")
+	}
+	if data, _ := os.ReadFile(pointer); string(data) != "../../original/.jj/repo" {
+		t.Errorf(".jj/repo = %q, want it restored", data)
+	}
+
+	// --delete-branch removes it regardless, without checking
+	f := newFakeExec(t, nil)
+	w.branchPolicy = BranchDelete
+	w.cleanup(context.Background())
+	if isDir(dir) || f.find("log") != nil {
+		t.Errorf("BranchDelete kept the workspace or checked it; ran:\n%s", f)
+	}
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	f := newFakeExec(t, nil)
+	workspace := t.TempDir()
+	w, err := createWorktree(context.Background(), worktreeOptions{vcs: "copy", workspace: workspace, prefix: DefaultPrefix, name: t.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "darwin" && !f.ran("cp", "-a", "--reflink=auto", workspace+"/.", w.dir) {
+		t.Errorf("workspace was not copied with reflinks; ran:\n%s", f)
+	}
+	if mounts := w.vcsMounts(workspace); len(mounts) != 0 {
+		t.Errorf("copy mounts VCS data: %+v", mounts)
+	}
+	w.cleanup(context.Background())
+	if isDir(w.dir) {
+		t.Error("copy was not removed")
+	}
+}
+
+func TestCleanupAfterCancel(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := &worktree{vcs: "git", dir: filepath.Join(t.TempDir(), "wt"), original: original}
+	w.cleanup(ctx)
+
+	if !f.ran("git", "-C", original, "worktree", "prune") {
+		t.Errorf("cleanup skipped after cancellation; ran:\n%s", f)
+	}
+}
+
+func TestCleanupNil(t *testing.T) {
+	f := newFakeExec(t, nil)
+	var w *worktree
+	w.cleanup(context.Background())
+	if len(f.calls) != 0 {
+		t.Errorf("nil worktree ran commands:\n%s", f)
+	}
+}
+
+func TestApplyDirty(t *testing.T) {
+	original := t.TempDir()
+	os.MkdirAll(filepath.Join(original, "new", "dir"), 0755)
+	os.WriteFile(filepath.Join(original, "new", "dir", "script.sh"), []byte("echo hi\n"), 0755)
+	os.Symlink("script.sh", filepath.Join(original, "new", "dir", "link"))
+	dir := t.TempDir()
+
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "create"):
+			return "0123abcd\n", 0
+		case slices.Contains(call, "ls-files"):
+			return "new/dir/script.sh\x00new/dir/link\x00", 0
+		}
+		return "", 0
+	})
+	w := &worktree{vcs: "git", dir: dir, original: original}
+	if err := w.applyDirty(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.ran("git", "-C", dir, "stash", "apply", "0123abcd") {
+		t.Errorf("stash was not applied in the worktree; ran:\n%s", f)
+	}
+	info, err := os.Stat(filepath.Join(dir, "new", "dir", "script.sh"))
+	if err != nil {
+		t.Fatalf("untracked file not copied: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("copied mode = %v, want 0755", info.Mode().Perm())
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "new", "dir", "link")); err != nil || target != "script.sh" {
+		t.Errorf("symlink = %q, %v; want script.sh", target, err)
+	}
+}
+
+func TestApplyDirtyClean(t *testing.T) {
+	f := newFakeExec(t, nil)
+	w := &worktree{vcs: "git", dir: t.TempDir(), original: t.TempDir()}
+	if err := w.applyDirty(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if f.ran("git", "-C", w.dir, "stash") {
+		t.Errorf("applied an empty stash; ran:\n%s", f)
+	}
+}
+
+func TestCleanupOriginalDeleted(t *testing.T) {
+	for _, vcs := range []string{"git", "jj"} {
+		t.Run(vcs, func(t *testing.T) {
+			f := newFakeExec(t, nil)
+			dir := filepath.Join(t.TempDir(), "devcontainer-abc")
+			os.Mkdir(dir, 0755)
+
+			w := &worktree{vcs: vcs, dir: dir, original: filepath.Join(t.TempDir(), "gone"), jjWorkspace: "devcontainer-abc"}
+			w.cleanup(context.Background())
+
+			if isDir(dir) {
+				t.Error("worktree dir was not removed")
+			}
+			if len(f.calls) != 0 {
+				t.Errorf("ran commands against the missing workspace:\n%s", f)
+			}
+		})
+	}
+}
+
+func TestHoldSignals(t *testing.T) {
+	release := holdSignals()
+	// Without the hold, the default SIGINT action kills the test binary.
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	time.Sleep(50 * time.Millisecond)
+	release()
+}
+
+func TestCreateWorktreeTrack(t *testing.T) {
+	remoteExists := true
+	f := newFakeExec(t, func(call []string) (string, int) {
+		switch {
+		case slices.Contains(call, "refs/remotes/origin/fix/login"), slices.Contains(call, `"fix/login"@"origin"`):
+			if remoteExists {
+				return "", 0
+			}
+			return "", 1
+		case slices.Contains(call, "rev-parse"):
+			return "", 1
+		}
+		return "", 0
+	})
+	original := t.TempDir()
+
+	w, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", name: "pr", track: "origin/fix/login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("git", "-C", original, "fetch", "origin", "fix/login") ||
+		!f.ran("git", "-C", original, "worktree", "add", "--track", "-b", "devcontainer-pr", w.dir, "origin/fix/login") {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+
+	f.calls = nil
+	if _, err := createWorktree(context.Background(), worktreeOptions{vcs: "jj", workspace: original, prefix: "devcontainer", name: "pr", track: "origin/fix/login"}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("jj", "-R", original, "git", "fetch", "--remote", "origin", "--branch", "fix/login") ||
+		!f.ran("jj", "-R", original, "workspace", "add", "--name", "devcontainer-pr", "--revision", `"fix/login"@"origin"`) {
+		t.Errorf("unexpected invocations:\n%s", f)
+	}
+
+	remoteExists = false
+	f.calls = nil
+	if _, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", track: "origin/fix/login"}); err == nil {
+		t.Error("created a worktree tracking a missing remote branch")
+	}
+	if f.ran("git", "-C", original, "worktree") {
+		t.Errorf("created a worktree for a missing remote branch; ran:\n%s", f)
+	}
+	if _, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", track: "origin"}); err == nil {
+		t.Error("accepted --track without a branch")
+	}
+}
+
+func TestBranchHint(t *testing.T) {
+	tests := []struct {
+		branch string
+		code   int
+		want   string
+	}{
+		{"Feature/Fix_Login", 0, "feature-fix-login"},
+		{"--x..y--", 0, "x-y"},
+		{"a-very-long-branch-name-that-goes-on-and-on", 0, "a-very-long-branch-name-that-goe"},
+		{"devcontainer-123", 0, ""},
+		{"", 1, ""}, // detached HEAD
+	}
+	for _, tt := range tests {
+		newFakeExec(t, func(call []string) (string, int) { return tt.branch + "\n", tt.code })
+		w := &worktree{vcs: "git", original: "/repo"}
+		if got := w.branchHint(context.Background(), "devcontainer"); got != tt.want {
+			t.Errorf("branchHint on %q = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+
+	newFakeExec(t, func(call []string) (string, int) { return "fix/login\n", 0 })
+	w, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: t.TempDir(), prefix: "devcontainer", nameFromBranch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(w.suffix, "fix-login-") || w.branch != "devcontainer-"+w.suffix || filepath.Base(w.dir) != w.branch {
+		t.Errorf("suffix = %q, branch = %q, dir = %q; want them derived from fix/login", w.suffix, w.branch, w.dir)
+	}
+}
+
+func TestRunCleanupCmdOutput(t *testing.T) {
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = orig })
+
+	code := 0
+	newFakeExec(t, func(call []string) (string, int) { return "pruning " + call[len(call)-1] + "\n", code })
+	w := &worktree{vcs: "git", original: "/repo"}
+	w.runCleanupCmd(context.Background(), "git", "worktree", "prune", "quiet")
+	code = 1
+	w.runCleanupCmd(context.Background(), "git", "worktree", "prune", "failed")
+	code = 0
+	w.verbose = true
+	w.runCleanupCmd(context.Background(), "git", "worktree", "prune", "verbose")
+
+	data, _ := os.ReadFile(stderr.Name())
+	if got := string(data); got != "pruning failed\npruning verbose\n" {
+		t.Errorf("stderr = %q, want only the failed and verbose commands' output", got)
+	}
+}
+
+func TestPersistentWorktree(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	name := "persist"
+	dir := filepath.Join(data, "claude-devcontainer", "worktrees", "devcontainer-"+name)
+	adminDir := filepath.Join(original, ".git", "worktrees", filepath.Base(dir))
+	os.MkdirAll(adminDir, 0755)
+	os.MkdirAll(dir, 0755)
+	// As left by a run that didn't clean up: the gitlink still points into
+	// the container
+	os.WriteFile(filepath.Join(dir, ".git"), []byte("gitdir: "+dotGitMount+"/worktrees/"+filepath.Base(dir)+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "work.txt"), []byte("in progress"), 0644)
+
+	opts := worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", name: name}
+	os.WriteFile(filepath.Join(adminDir, persistMarkerName), nil, 0644)
+	w, err := createWorktree(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.persistent || w.branch != "devcontainer-"+name || f.ran("git", "-C", original, "worktree", "add") {
+		t.Errorf("persistent worktree not reused: %+v; ran:\n%s", w, f)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, ".git")); string(data) != "gitdir: "+adminDir+"\n" {
+		t.Errorf("gitlink = %q, want it restored for the host", data)
+	}
+	w.cleanup(context.Background())
+	if !fileExists(filepath.Join(dir, "work.txt")) || f.ran("git", "-C", original, "worktree", "prune") {
+		t.Errorf("persistent worktree cleaned up; ran:\n%s", f)
+	}
+
+	opts.track = "origin/main"
+	if _, err := createWorktree(context.Background(), opts); err == nil {
+		t.Error("reused a persistent worktree for --track")
+	}
+
+	opts.track = ""
+	opts.force = true
+	if _, err := createWorktree(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(dir, "work.txt")) || !f.ran("git", "-C", original, "worktree", "add") {
+		t.Errorf("--force didn't recreate the persistent worktree; ran:\n%s", f)
+	}
+}
+
+func TestPersistentWorktreeLocation(t *testing.T) {
+	newFakeExec(t, nil)
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	opts := worktreeOptions{vcs: "git", workspace: t.TempDir(), prefix: "devcontainer", name: "feature", persist: true}
+	w, err := createWorktree(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(data, "claude-devcontainer", "worktrees", "devcontainer-feature"); w.dir != want {
+		t.Errorf("persistent worktree dir = %s, want %s", w.dir, want)
+	}
+	opts.persist = false
+	if dir := opts.namedDir(); filepath.Dir(dir) != os.TempDir() {
+		t.Errorf("worktree dir = %s, want it in the temp dir", dir)
+	}
+}
+
+func TestPersistentJJWorkspaceRepaired(t *testing.T) {
+	f := newFakeExec(t, nil)
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	original := t.TempDir()
+	os.MkdirAll(filepath.Join(original, ".jj", "repo"), 0755)
+	dir := filepath.Join(data, "claude-devcontainer", "worktrees", "devcontainer-feature")
+	// As left by a run that didn't clean up: .jj/repo removed for the
+	// container's mount, and an empty mount point in its place
+	os.MkdirAll(filepath.Join(dir, ".jj", "repo"), 0755)
+	os.WriteFile(filepath.Join(dir, ".jj", persistMarkerName), nil, 0644)
+
+	w, err := createWorktree(context.Background(), worktreeOptions{vcs: "jj", workspace: original, prefix: "devcontainer", name: "feature"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.persistent || w.dir != dir || f.ran("jj", "-R", original, "workspace", "add") {
+		t.Errorf("persistent workspace not reused: %+v; ran:\n%s", w, f)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, ".jj", "repo")); string(got) != filepath.Join(original, ".jj", "repo") {
+		t.Errorf(".jj/repo = %q, want it pointing at the original repo", got)
+	}
+}
+
+func TestStaleWorktreeReclaimed(t *testing.T) {
+	added := 0
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "add") {
+			if added++; added == 1 {
+				return fakeStderr + "fatal: '" + call[len(call)-2] + "' is a missing but locked worktree;\nuse 'add -f -f' to override, or 'unlock' and 'prune' or 'remove' to clear\n", 128
+			}
+		}
+		return "", 0
+	})
+	original := t.TempDir()
+	name := "stale-" + filepath.Base(t.TempDir())
+	// Without a terminal to confirm on, it takes --yes
+	_, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", name: name})
+	if err == nil || !strings.Contains(err.Error(), "--yes") || !strings.Contains(err.Error(), name) {
+		t.Errorf("createWorktree = %v, want --yes required to reclaim the path", err)
+	}
+	if f.ran("git", "-C", original, "worktree", "remove") {
+		t.Errorf("stale registration removed without confirmation; ran:\n%s", f)
+	}
+
+	added = 0
+	w, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", name: name, assumeYes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ran("git", "-C", original, "worktree", "remove", "-f", "-f", w.dir) || added != 2 {
+		t.Errorf("stale registration of %s not removed before retrying; ran:\n%s", w.dir, f)
+	}
+	if add := f.find("add"); slices.Contains(add, "-f") {
+		t.Errorf("git worktree add forced: %q", add)
+	}
+}
+
+func TestBranchInUseNotOverridden(t *testing.T) {
+	f := newFakeExec(t, func(call []string) (string, int) {
+		if slices.Contains(call, "add") {
+			return fakeStderr + "fatal: '" + call[len(call)-1] + "' is already checked out at '/home/me/repo'\n", 128
+		}
+		return "", 0
+	})
+	original := t.TempDir()
+	name := "inuse-" + filepath.Base(t.TempDir())
+	_, err := createWorktree(context.Background(), worktreeOptions{vcs: "git", workspace: original, prefix: "devcontainer", name: name})
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("createWorktree = %v, want the branch reported in use", err)
+	}
+	if f.ran("git", "-C", original, "worktree", "remove") {
+		t.Errorf("removed a worktree for a checked-out branch; ran:\n%s", f)
+	}
+}
+
+func TestWorktreeParent(t *testing.T) {
+	workspace := filepath.Join(t.TempDir(), "repo")
+	if got := worktreeParent("copy", workspace); got != filepath.Dir(workspace) {
+		t.Errorf("worktreeParent(copy) = %s, want beside the workspace", got)
+	}
+	if got := worktreeParent("git", workspace); got != os.TempDir() {
+		t.Errorf("worktreeParent(git) = %s, want the temp dir", got)
+	}
+}
+
+func TestKeptContainerWorktree(t *testing.T) {
+	f := newFakeExec(t, nil)
+	original := t.TempDir()
+	w := &worktree{vcs: "git", original: original, dir: t.TempDir(), keep: true, containerKept: true}
+	gitlink := filepath.Join(w.dir, ".git")
+	os.WriteFile(gitlink, []byte("gitdir: "+filepath.Join(original, ".git", "worktrees", "x")+"\n"), 0644)
+	w.vcsMounts(w.dir)
+	w.cleanup(context.Background())
+	if data, _ := os.ReadFile(gitlink); string(data) != "gitdir: "+dotGitMount+"/worktrees/x\n" {
+		t.Errorf("gitlink = %q, want it left pointing into the kept container", data)
+	}
+	if len(f.calls) > 0 {
+		t.Errorf("kept worktree cleaned up; ran:\n%s", f)
+	}
+}
+
+func TestRestoreForHostJJ(t *testing.T) {
+	ws := t.TempDir()
+	repo := t.TempDir()
+	// The empty mount point docker left for .jj/repo
+	os.MkdirAll(filepath.Join(ws, ".jj", "repo"), 0755)
+	dir, err := restoreForHost([]inspectedMount{
+		{Source: ws, Destination: "/src/repo"},
+		{Source: repo, Destination: "/src/repo/.jj/repo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(ws, ".jj", "repo")); dir != ws || string(data) != repo {
+		t.Errorf("restoreForHost = %s, .jj/repo = %q, want %s and %q", dir, data, ws, repo)
+	}
+	if dir, err := restoreForHost([]inspectedMount{{Source: "/home/u/go", Destination: "/home/dev/go"}}); dir != "" || err != nil {
+		t.Errorf("restoreForHost without a worktree = %q, %v", dir, err)
+	}
+}
@@ -1,34 +1,34 @@
 package main
 
 import (
-	"bufio"
-	_ "embed"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
-	"net"
+	"io"
 	"os"
-	"os/exec"
 	"os/signal"
-	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
-	"github.com/manifoldco/promptui"
+	"github.com/nobu-k/claude-devcontainer/devcontainer"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
+	"github.com/spf13/pflag"
 )
 
-//go:embed Dockerfile
-var dockerfile []byte
+// rt is the container runtime shared by all commands, set by the global
+// --docker-bin, --backend, and --context flags.
+var rt devcontainer.Runtime
 
-//go:embed .dockerignore
-var dockerignore []byte
+// workspaceStyle is how containers are labelled with their workspace, set
+// by the global --label-workspace-style flag so start and exec agree.
+var workspaceStyle string
+
+// namePrefix starts container, branch, and jj workspace names, set by the
+// global --prefix flag so start and exec agree.
+var namePrefix string
 
 // exitCodeError wraps a non-zero exit code so defers run before the process exits.
 type exitCodeError struct {
@@ -47,11 +47,39 @@ func main() {
 		SilenceErrors: true,
 	}
 
+	rootCmd.PersistentFlags().StringVar(&rt.DockerBin, "docker-bin", envOrDefault("DOCKER", "docker"), "docker-compatible CLI to invoke (e.g. podman)")
+	rootCmd.PersistentFlags().StringVar(&rt.Backend, "backend", envOrDefault("DEVCONTAINER_BACKEND", "auto"), "container backend: auto, docker, or podman")
+	rootCmd.PersistentFlags().StringVar(&rt.Context, "context", "", "docker context to run against (default: the active context; see 'contexts')")
+	rootCmd.PersistentFlags().StringVar(&workspaceStyle, "label-workspace-style", envOrDefault("DEVCONTAINER_LABEL_WORKSPACE_STYLE", "path"), "how containers are matched to the workspace: path, canonical (symlinks resolved), or remote (origin URL)")
+	rootCmd.PersistentFlags().StringVar(&namePrefix, "prefix", envOrDefault("DEVCONTAINER_PREFIX", devcontainer.DefaultPrefix), "prefix of container, branch, and jj workspace names")
+	rootCmd.PersistentFlags().BoolVar(&rt.NoSignalForward, "no-signal-forward", false, "don't relay SIGINT/SIGTERM to docker run/exec (for debugging)")
+	rootCmd.PersistentFlags().MarkHidden("no-signal-forward")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return rt.Validate()
+	}
+
 	rootCmd.AddCommand(newStartCmd())
 	rootCmd.AddCommand(newBuildCmd())
+	rootCmd.AddCommand(newUpdateCmd())
 	rootCmd.AddCommand(newExecCmd())
-
-	if err := rootCmd.Execute(); err != nil {
+	rootCmd.AddCommand(newListCmd())
+	rootCmd.AddCommand(newStopCmd())
+	rootCmd.AddCommand(newInspectCmd())
+	rootCmd.AddCommand(newDoctorCmd())
+	rootCmd.AddCommand(newContextsCmd())
+
+	// Cancel in-flight docker, git, and jj invocations on SIGINT/SIGTERM so
+	// long builds stop promptly and worktrees are still cleaned up. An
+	// attached docker run or exec isn't cancelled: it gets the signals
+	// instead and reports the container's exit code.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "devcontainer: interrupted")
+			os.Exit(130)
+		}
 		var ec exitCodeError
 		if errors.As(err, &ec) {
 			os.Exit(ec.code)
@@ -61,13 +89,35 @@ func main() {
 	}
 }
 
+// profileFlags are the start flags EffectiveConfig exports, or that only
+// choose what it prints.
+var profileFlags = map[string]bool{
+	"port": true, "volume": true, "tmpfs": true, "label": true, "build-arg": true,
+	"mount-credential": true, "mount-aws": true, "mount-gcloud": true,
+	"profile": true, "print-config": true, "work-tree": true,
+}
+
+// flagAfterResume returns the flag that directly follows a value-less
+// --resume in argv, or "" if there is none. Words after "--" are not
+// flags.
+func flagAfterResume(argv []string) string {
+	for i, a := range argv {
+		if a == "--" {
+			break
+		}
+		if a == "--resume" && i+1 < len(argv) && strings.HasPrefix(argv[i+1], "-") && argv[i+1] != "--" {
+			return argv[i+1]
+		}
+	}
+	return ""
+}
+
 func newStartCmd() *cobra.Command {
-	var flagName string
-	var flagVCS string
-	var flagDocker bool
-	var flagPorts []string
-	var flagVolumes []string
-	var flagResume string
+	var opts devcontainer.StartOptions
+	var attachStdin, interruptKill bool
+	var output string
+	var printConfig, printMounts bool
+	var keepBranch, deleteMergedBranch, deleteBranch, forceDelete bool
 
 	cmd := &cobra.Command{
 		Use:   "start [flags] [-- command...]",
@@ -75,696 +125,461 @@ func newStartCmd() *cobra.Command {
 		Long:  "Creates a Docker container with Claude Code and development tools, using VCS worktrees for isolation.",
 		Args:  cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output format: %s (expected 'text' or 'json')", output)
+			}
 			// When --resume is passed without '=' (e.g. --resume ID),
 			// NoOptDefVal causes cobra to treat ID as a positional arg.
-			// Consume the first positional arg as the session ID.
-			if strings.TrimSpace(flagResume) == "" && flagResume != "" && len(args) > 0 {
-				flagResume = args[0]
-				args = args[1:]
+			// Consume the first positional arg as the session ID, unless
+			// it follows "--" and so starts the command.
+			if strings.TrimSpace(opts.Resume) == "" && opts.Resume != "" {
+				opts.Resume = ""
+				if len(args) > 0 && cmd.ArgsLenAtDash() != 0 {
+					opts.Resume = args[0]
+					args = args[1:]
+				} else {
+					opts.ResumeLatest = true
+				}
+			}
+			// --resume only takes a value after '=', so --resume=--docker
+			// makes "--docker" the session ID; that was most likely meant
+			// as a separate flag.
+			if strings.HasPrefix(opts.Resume, "-") {
+				fmt.Fprintf(os.Stderr, "devcontainer: warning: --resume value %q looks like a flag; to resume the latest session, pass --resume without a value and %s as a separate flag\n", opts.Resume, opts.Resume)
+			}
+			// Given as --resume --docker, the flag after --resume is
+			// parsed on its own and the latest session is resumed. Say so,
+			// since it reads as if --docker could be the value.
+			if next := flagAfterResume(os.Args[1:]); next != "" && opts.ResumeLatest {
+				fmt.Fprintf(os.Stderr, "devcontainer: warning: %s after --resume is parsed as a separate flag, so the latest session is resumed; pass --resume=ID to resume a specific session\n", next)
+			}
+			if cmd.Flags().Changed("model") && opts.Model == "" {
+				return fmt.Errorf("invalid --model: must not be empty")
 			}
-			return run(flagName, flagVCS, flagDocker, flagPorts, flagVolumes, flagResume, args)
+			switch {
+			case keepBranch:
+				opts.BranchPolicy = devcontainer.BranchKeep
+			case deleteMergedBranch:
+				opts.BranchPolicy = devcontainer.BranchDeleteMerged
+			case deleteBranch, forceDelete:
+				opts.BranchPolicy = devcontainer.BranchDelete
+			}
+			opts.Runtime = rt
+			opts.WorkspaceStyle = devcontainer.WorkspaceStyle(workspaceStyle)
+			opts.Prefix = namePrefix
+			opts.Args = args
+			opts.NoStdin = !attachStdin
+			opts.NoInterruptKill = !interruptKill
+			if printConfig {
+				cfg, err := devcontainer.EffectiveConfig(opts)
+				if err != nil {
+					return err
+				}
+				var left []string
+				cmd.Flags().Visit(func(f *pflag.Flag) {
+					if !profileFlags[f.Name] {
+						left = append(left, "--"+f.Name)
+					}
+				})
+				if len(left) > 0 {
+					fmt.Fprintf(os.Stderr, "devcontainer: warning: a profile can't hold %s; they are left out of the printed config\n", strings.Join(left, ", "))
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(cfg)
+			}
+			if printMounts {
+				plans, err := devcontainer.PlanMounts(cmd.Context(), opts)
+				if err != nil {
+					return err
+				}
+				if output == "json" {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(plans)
+				}
+				return devcontainer.FormatMounts(os.Stdout, plans)
+			}
+			result, err := devcontainer.Start(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+			if opts.Detach || output == "json" {
+				if err := printStartResult(os.Stdout, result, output); err != nil {
+					return err
+				}
+			}
+			if result.ExitCode != 0 {
+				return exitCodeError{code: result.ExitCode}
+			}
+			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&flagName, "name", "", "name for worktree/container (default: random suffix)")
-	cmd.Flags().StringVar(&flagVCS, "vcs", "", "override VCS type: git or jj (default: auto-detect)")
-	cmd.Flags().BoolVar(&flagDocker, "docker", false, "mount Docker socket into the container")
-	cmd.Flags().StringArrayVar(&flagPorts, "port", nil, "publish a container port to the host (hostPort:containerPort)")
-	cmd.Flags().StringArrayVar(&flagVolumes, "volume", nil, "additional volume mount (host:container[:options])")
-	cmd.Flags().StringVar(&flagResume, "resume", "", "resume a Claude session by ID or name")
+	cmd.Flags().StringVar(&opts.Name, "name", "", "name for worktree/container (default: random suffix)")
+	cmd.Flags().BoolVar(&opts.NameFromBranch, "name-from-branch", false, "without --name, start the random suffix with the current git branch (e.g. devcontainer-fix-login-1234567)")
+	cmd.Flags().BoolVar(&opts.NoNewContainer, "no-new-container", false, "attach to a devcontainer already running for this workspace (matching --name, if given) instead of starting another")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "with --name, prune stale worktrees and delete a leftover branch before creating the worktree")
+	cmd.Flags().BoolVar(&opts.Persist, "persist", false, "with --name, keep the worktree and reuse it on later starts with that name (--force recreates it)")
+	cmd.Flags().BoolVar(&opts.KeepContainer, "keep-container", false, "keep the container (and its worktree) after its command exits, e.g. to restart a crashed session with exec --start")
+	cmd.Flags().BoolVar(&opts.KeepWorktree, "keep-worktree", false, "keep the worktree (and its branch) after the container exits")
+	cmd.Flags().BoolVar(&keepBranch, "keep-branch", false, "keep the git branch after the container exits while removing the worktree (the default)")
+	cmd.Flags().BoolVar(&deleteMergedBranch, "delete-merged-branch", false, "delete the git branch after the container exits if it is merged into HEAD")
+	cmd.Flags().BoolVar(&deleteBranch, "delete-branch", false, "delete the git branch (or forget the jj workspace) after the container exits even if it is unmerged")
+	cmd.Flags().BoolVar(&forceDelete, "force-delete", false, "remove the worktree and its git branch or jj workspace after the container exits even if they hold unmerged work")
+	cmd.Flags().BoolVar(&opts.KeepOnFailure, "keep-on-failure", false, "keep the worktree (and its branch) if the container's command fails, instead of removing it")
+	cmd.MarkFlagsMutuallyExclusive("keep-branch", "delete-merged-branch", "delete-branch", "force-delete")
+	cmd.MarkFlagsMutuallyExclusive("keep-worktree", "delete-merged-branch", "delete-branch", "force-delete")
+	cmd.MarkFlagsMutuallyExclusive("keep-on-failure", "force-delete")
+	cmd.Flags().BoolVar(&opts.IncludeDirty, "include-dirty", false, "start the worktree with your uncommitted changes (including untracked files) instead of the last commit")
+	cmd.Flags().StringVar(&opts.Track, "track", "", "fetch <remote>/<branch> and start the worktree from it, e.g. to review a PR branch")
+	cmd.Flags().BoolVar(&opts.CopyOnWrite, "copy-on-write", false, "isolate the container in a (reflinked, where supported) copy of the workspace instead of a VCS worktree")
+	cmd.Flags().StringVar(&opts.VCS, "vcs", "", "override VCS type: git or jj (default: auto-detect)")
+	cmd.Flags().StringVar(&opts.Workspace, "work-tree", "", "repository work tree to start from, overriding detection from the current directory")
+	cmd.Flags().StringVar(&opts.GitDir, "git-dir", "", "git directory of the work tree when it isn't <work-tree>/.git (implies --vcs git)")
+	cmd.Flags().StringVar(&opts.MountWorkspaceAt, "mount-workspace-at", "", "absolute path to mount the workspace at in the container (default: its host path)")
+	cmd.Flags().BoolVar(&opts.Docker, "docker", false, "mount Docker socket into the container")
+	cmd.Flags().BoolVarP(&opts.Yes, "yes", "y", false, "skip confirmation prompts (also $DEVCONTAINER_YES)")
+	cmd.Flags().BoolVarP(&opts.Verbose, "verbose", "v", false, "log extra detail about the container setup, and show the output of the commands cleaning up the worktree")
+	cmd.Flags().BoolVarP(&opts.Quiet, "quiet", "q", false, "hide the image build output behind a spinner (shown if the build fails)")
+	cmd.Flags().StringArrayVar(&opts.Ports, "port", nil, "publish a container port to the host (hostPort:containerPort)")
+	cmd.Flags().BoolVarP(&opts.PublishAll, "publish-all", "P", false, "publish every port the image EXPOSEs on a random host port, printing the assignments once the container is up")
+	cmd.Flags().StringArrayVar(&opts.Volumes, "volume", nil, "additional volume mount (host:container[:options])")
+	cmd.Flags().StringVar(&opts.Resume, "resume", "", "resume a Claude session by ID or name")
+	cmd.Flags().StringVar(&opts.PermissionMode, "permission-mode", "skip", "how claude asks for tool permissions: skip (--dangerously-skip-permissions), prompt (claude's own settings), or default (prompt for each tool)")
+	cmd.Flags().StringVar(&opts.Model, "model", "", "model for claude (passed as --model) when started with --resume or by default, e.g. opus")
+	cmd.Flags().StringVar(&opts.ResumeArgs, "resume-args", "", "extra claude arguments for --resume (e.g. '--model opus'), split like a shell would")
+	cmd.Flags().BoolVar(&opts.Shell, "shell", false, "open a login shell instead of claude, e.g. with --name to reopen a branch's environment")
+	cmd.Flags().StringVar(&opts.PullPolicy, "pull-policy", "missing", "when to pull the base image: always, missing, or never")
+	cmd.Flags().StringVar(&opts.Registry, "registry", "", "pull the devcontainer image from this registry (e.g. ghcr.io/me) instead of building it, for docker contexts that can't build it")
+	cmd.Flags().StringArrayVar(&opts.BuildArgs, "build-arg", nil, "extra docker build argument (KEY=VALUE); repeatable")
+	cmd.Flags().StringVar(&opts.Userns, "userns", "", "user namespace of the container (docker run --userns), e.g. host on a userns-remap daemon; replaces podman's keep-id")
+	cmd.Flags().StringVar(&opts.ContainerHome, "container-home", "", "home directory of the container user, where home mounts land; also passed as the USER_HOME build arg (default /home/dev)")
+	cmd.Flags().BoolVar(&opts.RemoveImage, "rm-image", false, "remove the image after the container exits if it is a --build-arg variant (the shared image is always kept)")
+	cmd.Flags().StringVar(&opts.CIDFile, "cidfile", "", "write the container ID to this file on start (it must not exist yet)")
+	cmd.Flags().StringVar(&opts.Events, "events", "", "write the container's create/start/die events as JSON Lines to this file (appended) or - for stderr")
+	cmd.Flags().StringVar(&opts.Freeze, "freeze", "", "when the command exits, commit the container to this image tag (e.g. bug:repro) for later inspection")
+	cmd.Flags().StringArrayVar(&opts.CopyOut, "copy-out", nil, "when the command exits, copy a file or directory out of the container (container-path:host-path, container paths relative to the workspace); repeatable")
+	cmd.Flags().StringVar(&opts.BuildContext, "build-context", "", "build the image from this directory's .devcontainer/Dockerfile, with the directory (relative to the repo root, e.g. '.') as the context so it can COPY repo files")
+	cmd.Flags().StringSliceVar(&opts.CapDrop, "cap-drop", []string{"ALL"}, "Linux capabilities to drop (comma-separated or repeated)")
+	cmd.Flags().StringSliceVar(&opts.CapAdd, "cap-add", nil, "Linux capabilities to add back after --cap-drop (comma-separated or repeated); commonly NET_RAW for ping, CHOWN/DAC_OVERRIDE/FOWNER for file ownership, SETUID/SETGID for sudo, SYS_PTRACE for debuggers")
+	cmd.Flags().StringVar(&opts.Memory, "memory", "", "container memory limit (e.g. 8g)")
+	cmd.Flags().StringVar(&opts.MemorySwap, "memory-swap", "", "container memory+swap limit (e.g. 12g, -1 for unlimited swap); requires --memory")
+	cmd.Flags().StringVar(&opts.MemoryReservation, "memory-reservation", "", "soft memory limit the container is reclaimed down to under host memory pressure (e.g. 4g)")
+	cmd.Flags().StringVar(&opts.ShmSize, "shm-size", "", "size of /dev/shm (e.g. 2g; docker's default of 64m crashes headless Chrome)")
+	cmd.Flags().IntVar(&opts.PidsLimit, "pids-limit", 0, "maximum number of processes in the container (0 for no limit)")
+	cmd.Flags().StringVar(&opts.StopSignal, "stop-signal", "", "signal docker sends to stop the container (default SIGTERM)")
+	cmd.Flags().IntVar(&opts.StopTimeout, "stop-timeout", 0, "seconds to wait after the stop signal before killing the container (0 for docker's default of 10)")
+	cmd.Flags().StringVar(&opts.HealthCmd, "health-cmd", "", "readiness check run in the container, overriding the image's HEALTHCHECK (e.g. 'curl -f localhost:8080/healthz')")
+	cmd.Flags().StringVar(&opts.HealthInterval, "health-interval", "", "time between --health-cmd runs (e.g. 10s; default 30s)")
+	cmd.Flags().IntVar(&opts.HealthRetries, "health-retries", 0, "consecutive --health-cmd failures before the container is unhealthy (0 for docker's default of 3)")
+	cmd.Flags().BoolVar(&opts.ReadOnlyClaudeJSON, "read-only-claude-json", false, "mount ~/.claude.json read-only so the container can't change it (claude can't save settings either)")
+	cmd.Flags().BoolVar(&opts.CopyClaudeConfig, "copy-claude-config", false, "mount a throwaway copy of ~/.claude.json that the container may change")
+	cmd.Flags().BoolVar(&opts.NoSSH, "no-ssh", false, "don't mount ~/.ssh into the container")
+	cmd.Flags().BoolVar(&opts.NoGitconfig, "no-gitconfig", false, "don't mount ~/.gitconfig into the container")
+	cmd.Flags().BoolVar(&opts.NoGH, "no-gh", false, "don't mount the gh CLI config (and its GitHub token) into the container")
+	cmd.Flags().BoolVar(&opts.NoSSHAgent, "no-ssh-agent", false, "don't forward the SSH agent ($SSH_AUTH_SOCK) into the container")
+	cmd.Flags().BoolVar(&opts.MountAWS, "mount-aws", false, "mount ~/.aws read-only into the container")
+	cmd.Flags().BoolVar(&opts.MountGcloud, "mount-gcloud", false, "mount ~/.config/gcloud read-only into the container")
+	cmd.Flags().BoolVar(&opts.MountGitCredentials, "mount-git-credentials", false, "mount ~/.git-credentials and the git credential cache read-only so git in the container can authenticate")
+	cmd.Flags().BoolVar(&opts.GoCache, "go-cache", false, "mount the host's Go module and build caches (from go env GOMODCACHE and GOCACHE) writable, so builds in the container share them")
+	cmd.Flags().StringArrayVar(&opts.MountCredentials, "mount-credential", nil, "mount a credential file or dir read-only at the same place under the container home (relative paths are under $HOME)")
+	cmd.Flags().BoolVar(&opts.EnvPassthroughClaude, "env-passthrough-claude", false, "forward the host's ANTHROPIC_* and CLAUDE_* environment variables into the container")
+	cmd.Flags().StringVar(&opts.CACert, "ca-cert", "", "trust this PEM CA bundle in the container (for node, git, cargo, and OpenSSL-based tools)")
+	cmd.Flags().StringVar(&opts.Locale, "locale", "", "set LANG and LC_ALL in the container (default: the host's $LANG; 'none' to leave the image's)")
+	cmd.Flags().BoolVar(&opts.Proxy, "proxy", false, "forward the host's HTTP_PROXY, HTTPS_PROXY and NO_PROXY (upper- or lowercase) into the container")
+	cmd.Flags().StringVar(&opts.Profile, "profile", "", "merge the named profile from the repository's "+devcontainer.ConfigFile+" with these flags")
+	cmd.Flags().BoolVar(&printConfig, "print-config", false, "print the profile settings of this invocation as "+devcontainer.ConfigFile+" instead of starting")
+	cmd.Flags().BoolVar(&printMounts, "print-mounts", false, "print the mounts this invocation would make (and those it would leave out, and why) instead of starting")
+	cmd.MarkFlagsMutuallyExclusive("print-config", "print-mounts")
+	cmd.Flags().StringArrayVar(&opts.Labels, "label", nil, "set a label on the container (key=value) for grouping with --label-filter")
+	cmd.Flags().StringArrayVar(&opts.LabelFiles, "label-file", nil, "set the labels listed in this file, one key=value per line (overridden by --label); repeatable")
+	cmd.Flags().StringArrayVar(&opts.Tmpfs, "tmpfs", nil, "mount a tmpfs in the container (path[:options], e.g. /scratch:size=1g,mode=1777)")
+	cmd.Flags().StringArrayVar(&opts.Devices, "device", nil, "pass a host device through to the container (host[:container[:permissions]], e.g. /dev/ttyUSB0); the container user is added to its group")
+	cmd.Flags().BoolVarP(&opts.Detach, "detach", "d", false, "start the container in the background and print its name, IP, and published ports")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 0, "kill the container if its command runs longer than this (e.g. 30m) and exit with 124")
+	cmd.Flags().BoolVar(&opts.Wait, "wait", false, "with --detach, return only once the container is running and, if it has a health check, healthy")
+	cmd.Flags().DurationVar(&opts.WaitTimeout, "wait-timeout", 2*time.Minute, "how long --wait waits for the container to become ready (0 for no limit)")
+	cmd.Flags().StringVar(&output, "output", "text", "format of the --detach summary: text or json (json is also printed after an attached run)")
+	cmd.Flags().BoolVar(&interruptKill, "interrupt-kill", true, "kill the container on a second Ctrl-C (SIGINT or SIGTERM) instead of forwarding it too; --interrupt-kill=false forwards every one")
+	cmd.Flags().BoolVar(&attachStdin, "attach-stdin", true, "attach stdin to the container; --attach-stdin=false still streams output until the command exits")
+	cmd.Flags().StringVar(&opts.DefaultCmd, "default-cmd", "", "command to run when neither --resume nor -- command is given (default: $DEVCONTAINER_DEFAULT_CMD, then the image's CMD)")
 	cmd.Flags().Lookup("resume").NoOptDefVal = " "
 
 	return cmd
 }
 
-type containerInfo struct {
-	ID    string `json:"ID"`
-	Names string `json:"Names"`
+// printStartResult writes the result of start in format ("text" or "json").
+func printStartResult(w io.Writer, r *devcontainer.Result, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	report := func(key, value string) {
+		fmt.Fprintf(w, "%-10s %s\n", key+":", value)
+	}
+	container := r.ContainerName
+	if r.ContainerID != "" {
+		container += " (" + r.ContainerID[:min(12, len(r.ContainerID))] + ")"
+	}
+	report("container", container)
+	if r.WorktreeDir != "" {
+		report("worktree", r.WorktreeDir)
+	}
+	if r.IPAddress != "" {
+		report("ip", r.IPAddress)
+	}
+	for _, p := range r.Ports {
+		report("port", fmt.Sprintf("%s -> %s:%s", p.ContainerPort, p.HostIP, p.HostPort))
+	}
+	return nil
 }
 
 func newBuildCmd() *cobra.Command {
-	var flagNoCache bool
+	var opts devcontainer.BuildOptions
 
 	cmd := &cobra.Command{
 		Use:   "build",
 		Short: "Rebuild the devcontainer image",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runBuild(flagNoCache)
+			opts.Runtime = rt
+			return devcontainer.Build(cmd.Context(), opts)
 		},
 	}
 
-	cmd.Flags().BoolVar(&flagNoCache, "no-cache", false, "build without using Docker layer cache")
+	cmd.Flags().BoolVar(&opts.NoCache, "no-cache", false, "build without using Docker layer cache")
+	cmd.Flags().StringVar(&opts.PullPolicy, "pull-policy", "missing", "when to pull the base image: always, missing, or never")
+	cmd.Flags().StringArrayVar(&opts.BuildArgs, "build-arg", nil, "extra docker build argument (KEY=VALUE); repeatable")
+	cmd.Flags().BoolVarP(&opts.Quiet, "quiet", "q", false, "hide the build output behind a spinner (shown if the build fails)")
 
 	return cmd
 }
 
-func runBuild(noCache bool) error {
-	imageName := envOrDefault("IMAGE_NAME", "claude-devcontainer")
-
-	u, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("getting current user: %w", err)
-	}
-
-	dockerGID := "984"
-	if info, err := os.Stat("/var/run/docker.sock"); err == nil {
-		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-			dockerGID = strconv.FormatUint(uint64(stat.Gid), 10)
-		}
-	}
+func newUpdateCmd() *cobra.Command {
+	var opts devcontainer.BuildOptions
 
-	contextDir, err := os.MkdirTemp("", "devcontainer-context-")
-	if err != nil {
-		return fmt.Errorf("creating context dir: %w", err)
-	}
-	defer os.RemoveAll(contextDir)
-
-	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), dockerfile, 0644); err != nil {
-		return fmt.Errorf("writing Dockerfile: %w", err)
-	}
-	if err := os.WriteFile(filepath.Join(contextDir, ".dockerignore"), dockerignore, 0644); err != nil {
-		return fmt.Errorf("writing .dockerignore: %w", err)
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Rebuild the image with the latest base image and tools, removing the old one",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Runtime = rt
+			result, err := devcontainer.Update(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+			short := func(id string) string {
+				id = strings.TrimPrefix(id, "sha256:")
+				return id[:min(12, len(id))]
+			}
+			switch {
+			case result.Before == "":
+				fmt.Printf("%s: built %s\n", result.Image, short(result.After))
+			case result.Before == result.After:
+				fmt.Printf("%s: unchanged (%s)\n", result.Image, short(result.After))
+			default:
+				fmt.Printf("%s: %s -> %s\n", result.Image, short(result.Before), short(result.After))
+			}
+			return nil
+		},
 	}
 
-	buildArgs := []string{"build",
-		"--build-arg", "USER_UID=" + u.Uid,
-		"--build-arg", "USER_GID=" + u.Gid,
-		"--build-arg", "DOCKER_GID=" + dockerGID,
-		"-t", imageName,
-	}
-	if noCache {
-		buildArgs = append(buildArgs, "--no-cache")
-	}
-	buildArgs = append(buildArgs, contextDir)
+	cmd.Flags().StringArrayVar(&opts.BuildArgs, "build-arg", nil, "extra docker build argument (KEY=VALUE) of the image variant to update; repeatable")
+	cmd.Flags().BoolVarP(&opts.Quiet, "quiet", "q", false, "hide the build output behind a spinner (shown if the build fails)")
 
-	return runCmd("docker", buildArgs...)
+	return cmd
 }
 
 func newExecCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "exec [container-name]",
+	var opts devcontainer.ExecOptions
+
+	cmd := &cobra.Command{
+		Use:   "exec [container-name-or-id]",
 		Short: "Attach to a running devcontainer",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var target string
 			if len(args) > 0 {
-				target = args[0]
+				opts.Target = args[0]
 			}
-			workspaceDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
-			if workspaceDir == "" {
-				var err error
-				workspaceDir, err = os.Getwd()
-				if err != nil {
-					return fmt.Errorf("getting working directory: %w", err)
-				}
-				workspaceDir = findVCSRoot(workspaceDir)
+			workspaceDir, err := devcontainer.DefaultWorkspace()
+			if err != nil {
+				return err
 			}
-			name, err := resolveContainer(target, workspaceDir)
+			opts.Runtime = rt
+			opts.Workspace = workspaceDir
+			opts.WorkspaceStyle = devcontainer.WorkspaceStyle(workspaceStyle)
+			opts.Prefix = namePrefix
+			exitCode, err := devcontainer.Exec(cmd.Context(), opts)
 			if err != nil {
 				return err
 			}
-			return runExec(name)
+			if exitCode != 0 {
+				return exitCodeError{code: exitCode}
+			}
+			return nil
 		},
 	}
-}
-
-func listDevcontainers(workspaceDir string) ([]containerInfo, error) {
-	args := []string{"ps",
-		"--filter", "name=devcontainer-",
-		"--filter", "name=claude-dev",
-	}
-	if workspaceDir != "" {
-		args = append(args, "--filter", "label=claude-devcontainer.workspace="+workspaceDir)
-	}
-	args = append(args, "--format", "{{json .}}")
 
-	out, err := exec.Command("docker", args...).Output()
-	if err != nil {
-		return nil, fmt.Errorf("listing containers: %w", err)
-	}
+	cmd.Flags().StringArrayVar(&opts.LabelFilters, "label-filter", nil, "only consider devcontainers with this label (key=value or key); repeatable")
+	cmd.Flags().StringVar(&opts.Select, "select", "", "when several devcontainers match, pick one without prompting: first, newest, or oldest")
+	cmd.Flags().StringVar(&opts.NameSuffix, "name-suffix", "", "name this session <container>-<suffix> (in messages and $DEVCONTAINER_SESSION) to tell concurrent shells apart")
+	cmd.Flags().BoolVar(&opts.Start, "start", false, "if no matching devcontainer is running, start a stopped one and attach to it")
+	cmd.Flags().BoolVar(&opts.NoLogin, "no-login", false, "run a plain bash instead of a login shell (skips ~/.bash_profile and ~/.profile)")
+	cmd.Flags().StringVar(&opts.Script, "script", "", "copy this script into the devcontainer and run it with bash instead of opening a shell")
+	cmd.Flags().BoolVar(&opts.FailFast, "fail-fast", false, "with --script, stop at the first failing command (bash -e)")
 
-	var containers []containerInfo
-	scanner := bufio.NewScanner(strings.NewReader(string(out)))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		var ci containerInfo
-		if err := json.Unmarshal([]byte(line), &ci); err != nil {
-			continue
-		}
-		containers = append(containers, ci)
-	}
-	return containers, nil
+	return cmd
 }
 
-func resolveContainer(target, workspaceDir string) (string, error) {
-	containers, err := listDevcontainers(workspaceDir)
-	if err != nil {
-		return "", err
-	}
-	if len(containers) == 0 {
-		return "", fmt.Errorf("no running devcontainers found")
-	}
+func newListCmd() *cobra.Command {
+	var opts devcontainer.ListOptions
+	var asJSON bool
+	var format string
 
-	if target != "" {
-		for _, c := range containers {
-			if c.Names == target || c.Names == "devcontainer-"+target {
-				return c.Names, nil
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List devcontainers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Runtime = rt
+			opts.WorkspaceStyle = devcontainer.WorkspaceStyle(workspaceStyle)
+			opts.Prefix = namePrefix
+			if opts.Workspace != "" {
+				abs, err := filepath.Abs(opts.Workspace)
+				if err != nil {
+					return err
+				}
+				opts.Workspace = abs
 			}
-		}
-		return "", fmt.Errorf("no running devcontainer matching %q", target)
-	}
-
-	if len(containers) == 1 {
-		return containers[0].Names, nil
-	}
-
-	return promptSelectContainer(containers)
-}
-
-func promptSelectContainer(containers []containerInfo) (string, error) {
-	if !term.IsTerminal(int(os.Stdin.Fd())) {
-		return "", fmt.Errorf("multiple devcontainers running; specify a name or run interactively")
-	}
-
-	items := make([]string, len(containers))
-	for i, c := range containers {
-		items[i] = c.Names
-	}
-
-	prompt := promptui.Select{
-		Label:  "Select a devcontainer",
-		Items:  items,
-		Stdout: os.Stderr,
-	}
-
-	idx, _, err := prompt.Run()
-	if err != nil {
-		return "", fmt.Errorf("selection: %w", err)
-	}
-	return containers[idx].Names, nil
-}
-
-func runExec(containerName string) error {
-	dockerArgs := []string{"exec", "-i"}
-	if term.IsTerminal(int(os.Stdin.Fd())) {
-		dockerArgs = append(dockerArgs, "-t")
-	}
-	dockerArgs = append(dockerArgs, containerName, "bash")
-
-	dockerCmd := exec.Command("docker", dockerArgs...)
-	dockerCmd.Stdin = os.Stdin
-	dockerCmd.Stdout = os.Stdout
-	dockerCmd.Stderr = os.Stderr
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	if err := dockerCmd.Start(); err != nil {
-		return fmt.Errorf("starting docker exec: %w", err)
-	}
-
-	go func() {
-		for sig := range sigCh {
-			if dockerCmd.Process != nil {
-				dockerCmd.Process.Signal(sig)
+			containers, err := devcontainer.ListContainers(cmd.Context(), opts)
+			if err != nil {
+				return err
 			}
-		}
-	}()
-
-	exitCode := 0
-	if err := dockerCmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			return fmt.Errorf("running docker exec: %w", err)
-		}
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(containers)
+			}
+			return devcontainer.FormatContainers(os.Stdout, containers, format)
+		},
 	}
 
-	signal.Stop(sigCh)
-	close(sigCh)
+	cmd.Flags().BoolVarP(&opts.All, "all", "a", false, "include stopped devcontainers")
+	cmd.Flags().StringVar(&opts.Workspace, "workspace", "", "only list devcontainers started from this workspace")
+	cmd.Flags().StringArrayVar(&opts.LabelFilters, "label-filter", nil, "only list devcontainers with this label (key=value or key); repeatable")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the devcontainers as a JSON array")
+	cmd.Flags().StringVar(&format, "format", "", "render each devcontainer with a Go template, e.g. '{{.Names}} {{.Status}}' (default: name, status, and workspace columns)")
+	cmd.MarkFlagsMutuallyExclusive("json", "format")
 
-	if exitCode != 0 {
-		return exitCodeError{code: exitCode}
-	}
-	return nil
+	return cmd
 }
 
-func run(name, vcsFlag string, docker bool, ports []string, volumes []string, resume string, extraArgs []string) error {
-	if resume != "" && len(extraArgs) > 0 {
-		return fmt.Errorf("cannot combine --resume with extra command arguments")
-	}
-
-	// Validate port mappings
-	for _, p := range ports {
-		parts := strings.SplitN(p, ":", 2)
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid port format %q: expected hostPort:containerPort", p)
-		}
-		if _, err := strconv.Atoi(parts[0]); err != nil {
-			return fmt.Errorf("invalid host port in %q: %w", p, err)
-		}
-		if _, err := strconv.Atoi(parts[1]); err != nil {
-			return fmt.Errorf("invalid container port in %q: %w", p, err)
-		}
-	}
-
-	containerName := envOrDefault("CONTAINER_NAME", "claude-dev")
-	imageName := envOrDefault("IMAGE_NAME", "claude-devcontainer")
+func newStopCmd() *cobra.Command {
+	var opts devcontainer.StopOptions
 
-	workspaceDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
-	if workspaceDir == "" {
-		var err error
-		workspaceDir, err = os.Getwd()
-		if err != nil {
-			return fmt.Errorf("getting working directory: %w", err)
-		}
-		workspaceDir = findVCSRoot(workspaceDir)
-	}
-
-	// VCS resolution: flag > env > auto-detect
-	vcs := vcsFlag
-	if vcs == "" {
-		vcs = os.Getenv("DEVCONTAINER_VCS")
-	}
-	if vcs == "" {
-		if isDir(filepath.Join(workspaceDir, ".jj")) {
-			vcs = "jj"
-		} else if isDir(filepath.Join(workspaceDir, ".git")) {
-			vcs = "git"
-		}
-	}
-	if vcs != "" && vcs != "git" && vcs != "jj" {
-		return fmt.Errorf("unknown VCS type: %s (expected 'git' or 'jj')", vcs)
-	}
-
-	var worktreeDir string
-	var originalWorkspace string
-	var branchName string   // git only
-	var worktreeName string // jj only
-	var suffix string
-
-	if vcs != "" {
-		if name != "" {
-			suffix = name
-			worktreeDir = filepath.Join(os.TempDir(), "devcontainer-"+name)
-			// Remove existing directory if present
-			os.RemoveAll(worktreeDir)
-		} else {
-			dir, err := os.MkdirTemp("", "devcontainer-")
-			if err != nil {
-				return fmt.Errorf("creating temp dir: %w", err)
+	cmd := &cobra.Command{
+		Use:   "stop [container-name-or-id]",
+		Short: "Stop devcontainers, one or every match of --label-filter with --all",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				opts.Target = args[0]
 			}
-			// Remove it — VCS will recreate
-			os.Remove(dir)
-			suffix = filepath.Base(dir)
-			// suffix already starts with "devcontainer-", strip it for the name
-			suffix = strings.TrimPrefix(suffix, "devcontainer-")
-			worktreeDir = dir
-		}
-
-		containerName = "devcontainer-" + suffix
-
-		switch vcs {
-		case "git":
-			branchName = "devcontainer-" + suffix
-			// Check if branch already exists (e.g. from a previous run whose
-			// worktree was cleaned up but the branch was kept).
-			if exec.Command("git", "-C", workspaceDir, "rev-parse", "--verify", branchName).Run() == nil {
-				// Branch exists — attach worktree without -b
-				if err := runCmd("git", "-C", workspaceDir, "worktree", "add", worktreeDir, branchName); err != nil {
-					return fmt.Errorf("creating git worktree: %w", err)
-				}
-			} else {
-				if err := runCmd("git", "-C", workspaceDir, "worktree", "add", "-b", branchName, worktreeDir); err != nil {
-					return fmt.Errorf("creating git worktree: %w", err)
+			opts.Runtime = rt
+			opts.WorkspaceStyle = devcontainer.WorkspaceStyle(workspaceStyle)
+			opts.Prefix = namePrefix
+			if opts.Workspace != "" {
+				abs, err := filepath.Abs(opts.Workspace)
+				if err != nil {
+					return err
 				}
+				opts.Workspace = abs
 			}
-		case "jj":
-			worktreeName = "devcontainer-" + suffix
-			if err := runCmd("jj", "-R", workspaceDir, "workspace", "add", "--name", worktreeName, worktreeDir); err != nil {
-				return fmt.Errorf("creating jj workspace: %w", err)
+			stopped, err := devcontainer.Stop(cmd.Context(), opts)
+			for _, name := range stopped {
+				fmt.Println(name)
 			}
-		}
-
-		originalWorkspace = workspaceDir
-		workspaceDir = worktreeDir
-	}
-
-	// containerWorkspace is the path where the workspace appears inside the
-	// container.  Using the host path lets Claude Code share session data
-	// between host and container (sessions are keyed by absolute path).
-	containerWorkspace := workspaceDir
-	if originalWorkspace != "" {
-		containerWorkspace = originalWorkspace
-	}
-
-	// Write embedded files to temp dir for docker build context
-	contextDir, err := os.MkdirTemp("", "devcontainer-context-")
-	if err != nil {
-		return fmt.Errorf("creating context dir: %w", err)
-	}
-	defer os.RemoveAll(contextDir)
-
-	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), dockerfile, 0644); err != nil {
-		return fmt.Errorf("writing Dockerfile: %w", err)
-	}
-	if err := os.WriteFile(filepath.Join(contextDir, ".dockerignore"), dockerignore, 0644); err != nil {
-		return fmt.Errorf("writing .dockerignore: %w", err)
-	}
-
-	// Detect UID/GID
-	u, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("getting current user: %w", err)
-	}
-	hostUID := u.Uid
-	hostGID := u.Gid
-
-	// Docker socket GID
-	dockerSock := "/var/run/docker.sock"
-	dockerGID := "984" // fallback
-	if info, err := os.Stat(dockerSock); err == nil {
-		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-			dockerGID = strconv.FormatUint(uint64(stat.Gid), 10)
-		}
-	}
-
-	devHome := "/home/dev"
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("getting home dir: %w", err)
-	}
-
-	// Build image
-	if err := runCmd("docker", "build",
-		"--build-arg", "USER_UID="+hostUID,
-		"--build-arg", "USER_GID="+hostGID,
-		"--build-arg", "DOCKER_GID="+dockerGID,
-		"-t", imageName,
-		contextDir,
-	); err != nil {
-		return fmt.Errorf("docker build: %w", err)
-	}
-
-	// Remove pre-existing container (suppress errors if it doesn't exist)
-	rmCmd := exec.Command("docker", "rm", "-f", containerName)
-	rmCmd.Stdout = nil
-	rmCmd.Stderr = nil
-	rmCmd.Run()
-
-	// Trust the container workspace path in claude.json
-	claudeJSON := filepath.Join(homeDir, ".claude.json")
-	if err := trustWorkspace(claudeJSON, containerWorkspace); err != nil {
-		// Non-fatal: warn and continue
-		fmt.Fprintf(os.Stderr, "warning: could not update %s: %v\n", claudeJSON, err)
-	}
-
-	// Build mount and env arguments
-	var mounts []string
-	var envArgs []string
-
-	addMount := func(src, dst string, ro bool) {
-		opt := ""
-		if ro {
-			opt = ":ro"
-		}
-		mounts = append(mounts, "-v", src+":"+dst+opt)
-	}
-
-	addMount(workspaceDir, containerWorkspace, false)
-	addMount(filepath.Join(homeDir, ".cache/bazelisk"), devHome+"/.cache/bazelisk", true)
-	addMount(filepath.Join(homeDir, ".cargo"), devHome+"/.cargo", false)
-	addMount(filepath.Join(homeDir, ".rustup"), devHome+"/.rustup", true)
-	addMount(filepath.Join(homeDir, "go"), devHome+"/go", true)
-	addMount(filepath.Join(homeDir, "dev/go"), devHome+"/gopath", false)
-	addMount(filepath.Join(homeDir, ".npm"), devHome+"/.npm", false)
-	addMount(filepath.Join(homeDir, ".cache/pnpm"), devHome+"/.cache/pnpm", true)
-	addMount(filepath.Join(homeDir, ".claude"), devHome+"/.claude", false)
-	addMount(filepath.Join(homeDir, ".claude.json"), devHome+"/.claude.json", false)
-
-	// Bazel output base (only if repo uses Bazel)
-	bazelWorkspace := workspaceDir
-	if originalWorkspace != "" {
-		bazelWorkspace = originalWorkspace
-	}
-	if fileExists(filepath.Join(bazelWorkspace, "MODULE.bazel")) {
-		cmd := exec.Command("bazel", "info", "output_base")
-		cmd.Dir = bazelWorkspace
-		out, err := cmd.Output()
-		if err == nil {
-			outputBase := strings.TrimSpace(string(out))
-			bazelRC, err := os.CreateTemp("", "bazel-rc-")
-			if err == nil {
-				fmt.Fprintf(bazelRC, "startup --output_base=%s\n", outputBase)
-				bazelRC.Close()
-				addMount(outputBase, outputBase, false)
-				addMount(bazelRC.Name(), "/etc/bazel.bazelrc", true)
-				defer os.Remove(bazelRC.Name())
-			}
-		}
-	}
-
-	// Docker socket (opt-in)
-	if docker && isSocket(dockerSock) {
-		addMount(dockerSock, dockerSock, false)
-	}
-
-	// Conditional mounts
-	if fileExists(filepath.Join(homeDir, ".gitconfig")) {
-		addMount(filepath.Join(homeDir, ".gitconfig"), devHome+"/.gitconfig", true)
-	}
-	if isDir(filepath.Join(homeDir, ".config/gh")) {
-		addMount(filepath.Join(homeDir, ".config/gh"), devHome+"/.config/gh", true)
-	}
-	if isDir(filepath.Join(homeDir, ".config/jj")) {
-		addMount(filepath.Join(homeDir, ".config/jj"), devHome+"/.config/jj", true)
-	}
-	if isDir(filepath.Join(homeDir, ".ssh")) {
-		addMount(filepath.Join(homeDir, ".ssh"), devHome+"/.ssh", true)
+			return err
+		},
 	}
 
-	// SSH agent forwarding
-	if sshSock := os.Getenv("SSH_AUTH_SOCK"); sshSock != "" {
-		addMount(sshSock, "/tmp/ssh-agent.sock", false)
-		envArgs = append(envArgs, "-e", "SSH_AUTH_SOCK=/tmp/ssh-agent.sock")
-	}
+	cmd.Flags().BoolVar(&opts.Each, "all", false, "stop every matching devcontainer instead of one (confirmed if more than one)")
+	cmd.Flags().StringVar(&opts.Workspace, "workspace", "", "only stop devcontainers started from this workspace")
+	cmd.Flags().StringArrayVar(&opts.LabelFilters, "label-filter", nil, "only stop devcontainers with this label (key=value or key); repeatable")
+	cmd.Flags().StringVar(&opts.Select, "select", "", "when several devcontainers match and --all isn't given, pick one without prompting: first, newest, or oldest")
+	cmd.Flags().BoolVarP(&opts.Yes, "yes", "y", false, "skip the confirmation of --all (also $DEVCONTAINER_YES)")
+	cmd.Flags().BoolVar(&opts.Remove, "rm", false, "remove the containers too, stopped ones included (e.g. kept by --keep-container), setting up their worktrees for the host again")
 
-	// VS Code editor proxy: let Ctrl-G open a VS Code tab on the host
-	var editorListener net.Listener
-	var editorWG *sync.WaitGroup
-	if codePath, err := exec.LookPath("code"); err == nil {
-		editorDir, err := os.MkdirTemp("", "claude-editor-")
-		if err == nil {
-			editorListener, editorWG, err = startEditorProxy(editorDir, codePath)
-			if err == nil {
-				addMount(editorDir, "/tmp/claude-editor", false)
-				envArgs = append(envArgs, "-e", "VISUAL=vscode-editor")
-				fmt.Fprintf(os.Stderr, "devcontainer: editor proxy started (code=%s)\n", codePath)
-				defer func() {
-					editorListener.Close()
-					done := make(chan struct{})
-					go func() { editorWG.Wait(); close(done) }()
-					select {
-					case <-done:
-					case <-time.After(2 * time.Second):
-					}
-					os.RemoveAll(editorDir)
-				}()
-			} else {
-				os.RemoveAll(editorDir)
-			}
-		}
-	}
+	return cmd
+}
 
-	// Host timezone
-	if tz := detectTimezone(); tz != "" {
-		envArgs = append(envArgs, "-e", "TZ="+tz)
-	}
+func newInspectCmd() *cobra.Command {
+	var opts devcontainer.InspectOptions
 
-	// Worktree VCS backend: mount original repo's VCS dir
-	if worktreeDir != "" {
-		switch vcs {
-		case "git":
-			// Mount the original .git at a non-conflicting path. We can't
-			// mount it at containerWorkspace/.git because the worktree has
-			// a .git gitlink file there (Docker can't mount a directory
-			// over a file). Rewrite the gitlink to point to the mounted
-			// path so git preserves the worktree identity and uses the
-			// worktree's own index/HEAD instead of the main ones.
-			dotGitMount := "/.devcontainer-git"
-			gitlinkPath := filepath.Join(worktreeDir, ".git")
-			if data, err := os.ReadFile(gitlinkPath); err == nil {
-				gitdir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir: "))
-				hostDotGit := filepath.Join(originalWorkspace, ".git")
-				newGitdir := strings.Replace(gitdir, hostDotGit, dotGitMount, 1)
-				os.WriteFile(gitlinkPath, []byte("gitdir: "+newGitdir+"\n"), 0644)
+	cmd := &cobra.Command{
+		Use:   "inspect [container-name-or-id]",
+		Short: "Summarize a devcontainer's labels, workspace, and mounts",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				opts.Target = args[0]
 			}
-			addMount(filepath.Join(originalWorkspace, ".git"), dotGitMount, false)
-		case "jj":
-			// The workspace contains a .jj/repo file (pointer to the
-			// original repo), but we need to bind-mount the original
-			// .jj/repo directory over it. Remove the file first.
-			os.Remove(filepath.Join(worktreeDir, ".jj", "repo"))
-			addMount(filepath.Join(originalWorkspace, ".jj/repo"), originalWorkspace+"/.jj/repo", false)
-			// If jj uses a git backend, also mount the git repo it points to.
-			gitTargetFile := filepath.Join(originalWorkspace, ".jj", "repo", "store", "git_target")
-			if data, err := os.ReadFile(gitTargetFile); err == nil {
-				target := strings.TrimSpace(string(data))
-				if !filepath.IsAbs(target) {
-					target = filepath.Join(originalWorkspace, ".jj", "repo", "store", target)
-				}
-				target = filepath.Clean(target)
-				// Only mount if not already under .jj/repo (which is already mounted)
-				jjRepo := filepath.Clean(filepath.Join(originalWorkspace, ".jj", "repo"))
-				if !strings.HasPrefix(target, jjRepo+string(filepath.Separator)) && target != jjRepo {
-					if isDir(target) {
-						addMount(target, target, false)
-					}
+			opts.Runtime = rt
+			opts.WorkspaceStyle = devcontainer.WorkspaceStyle(workspaceStyle)
+			opts.Prefix = namePrefix
+			if opts.Workspace != "" {
+				abs, err := filepath.Abs(opts.Workspace)
+				if err != nil {
+					return err
 				}
+				opts.Workspace = abs
 			}
-		}
-	}
-
-	// Build docker run args
-	dockerArgs := []string{"run", "--rm", "-i",
-		"--cap-drop=ALL",
-		"--security-opt=no-new-privileges",
-		"--label", "claude-devcontainer.workspace=" + containerWorkspace,
-		"-w", containerWorkspace,
-		"--name", containerName,
-	}
-
-	// Allocate TTY if stdin is a terminal
-	if term.IsTerminal(int(os.Stdin.Fd())) {
-		dockerArgs = append(dockerArgs, "-t")
-	}
-
-	dockerArgs = append(dockerArgs, mounts...)
-	dockerArgs = append(dockerArgs, envArgs...)
-	for _, p := range ports {
-		dockerArgs = append(dockerArgs, "-p", p)
-	}
-	for _, v := range volumes {
-		// Resolve relative host paths against the workspace root so that
-		// Docker treats them as bind mounts instead of named volumes.
-		if parts := strings.SplitN(v, ":", 2); len(parts) >= 2 && !filepath.IsAbs(parts[0]) {
-			parts[0] = filepath.Join(workspaceDir, parts[0])
-			v = strings.Join(parts, ":")
-		}
-		dockerArgs = append(dockerArgs, "-v", v)
-	}
-	dockerArgs = append(dockerArgs, imageName)
-	if resume != "" {
-		dockerArgs = append(dockerArgs, "claude", "--dangerously-skip-permissions", "--resume")
-		if strings.TrimSpace(resume) != "" {
-			dockerArgs = append(dockerArgs, resume)
-		}
-	} else {
-		dockerArgs = append(dockerArgs, extraArgs...)
-	}
-
-	// Run docker as subprocess with signal forwarding
-	dockerCmd := exec.Command("docker", dockerArgs...)
-	dockerCmd.Stdin = os.Stdin
-	dockerCmd.Stdout = os.Stdout
-	dockerCmd.Stderr = os.Stderr
-
-	// Forward signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	if err := dockerCmd.Start(); err != nil {
-		cleanupWorktree(worktreeDir, vcs, originalWorkspace, branchName, worktreeName)
-		return fmt.Errorf("starting docker: %w", err)
-	}
-
-	go func() {
-		for sig := range sigCh {
-			if dockerCmd.Process != nil {
-				dockerCmd.Process.Signal(sig)
-			}
-		}
-	}()
-
-	exitCode := 0
-	if err := dockerCmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			cleanupWorktree(worktreeDir, vcs, originalWorkspace, branchName, worktreeName)
-			return fmt.Errorf("running docker: %w", err)
-		}
+			return devcontainer.Inspect(cmd.Context(), opts, os.Stdout)
+		},
 	}
 
-	signal.Stop(sigCh)
-	close(sigCh)
-
-	// Cleanup worktree
-	cleanupWorktree(worktreeDir, vcs, originalWorkspace, branchName, worktreeName)
+	cmd.Flags().BoolVarP(&opts.All, "all", "a", false, "include stopped devcontainers")
+	cmd.Flags().StringVar(&opts.Workspace, "workspace", "", "only consider devcontainers started from this workspace")
+	cmd.Flags().StringArrayVar(&opts.LabelFilters, "label-filter", nil, "only consider devcontainers with this label (key=value or key); repeatable")
+	cmd.Flags().StringVar(&opts.Select, "select", "", "when several devcontainers match, pick one without prompting: first, newest, or oldest")
+	cmd.Flags().BoolVar(&opts.Raw, "raw", false, "print docker inspect's full JSON instead of the summary")
 
-	if exitCode != 0 {
-		return exitCodeError{code: exitCode}
-	}
-	return nil
+	return cmd
 }
 
-func cleanupWorktree(worktreeDir, vcs, originalWorkspace, branchName, worktreeName string) {
-	if worktreeDir == "" {
-		return
-	}
-	switch vcs {
-	case "git":
-		// The .git gitlink file is removed before container start so
-		// Docker can bind-mount the original .git directory. Because of
-		// this, "git worktree remove" would fail. Instead, delete the
-		// directory and prune stale worktree metadata.
-		os.RemoveAll(worktreeDir)
-		runCmd("git", "-C", originalWorkspace, "worktree", "prune")
-	case "jj":
-		runCmd("jj", "-R", originalWorkspace, "workspace", "forget", worktreeName)
-		os.RemoveAll(worktreeDir)
+func newDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Report the detected container backend and tool availability",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return devcontainer.Doctor(cmd.Context(), rt, namePrefix, os.Stdout)
+		},
 	}
 }
 
-func trustWorkspace(claudeJSONPath string, workspacePath string) error {
-	if !fileExists(claudeJSONPath) {
-		return nil
-	}
-
-	data, err := os.ReadFile(claudeJSONPath)
-	if err != nil {
-		return err
-	}
-
-	var config map[string]interface{}
-	if err := json.Unmarshal(data, &config); err != nil {
-		return err
-	}
-
-	projects, ok := config["projects"].(map[string]interface{})
-	if !ok {
-		projects = make(map[string]interface{})
-		config["projects"] = projects
-	}
-
-	workspace, ok := projects[workspacePath].(map[string]interface{})
-	if !ok {
-		workspace = make(map[string]interface{})
-		projects[workspacePath] = workspace
-	}
-
-	workspace["hasTrustDialogAccepted"] = true
-	workspace["hasCompletedProjectOnboarding"] = true
-
-	out, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return err
+func newContextsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "contexts",
+		Short: "List the docker contexts available to --context",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			contexts, err := devcontainer.ListContexts(cmd.Context(), rt)
+			if err != nil {
+				return err
+			}
+			for _, c := range contexts {
+				current := " "
+				if c.Name == rt.Context || (rt.Context == "" && c.Current) {
+					current = "*"
+				}
+				fmt.Printf("%s %-20s %s\n", current, c.Name, c.DockerEndpoint)
+			}
+			return nil
+		},
 	}
-
-	return os.WriteFile(claudeJSONPath, append(out, '\n'), 0644)
 }
 
 func envOrDefault(key, def string) string {
@@ -773,120 +588,3 @@ func envOrDefault(key, def string) string {
 	}
 	return def
 }
-
-func runCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// findVCSRoot walks up from dir looking for a .jj or .git directory,
-// returning the containing directory. Returns dir unchanged if no VCS root
-// is found.
-func findVCSRoot(dir string) string {
-	cur := dir
-	for {
-		if isDir(filepath.Join(cur, ".jj")) || isDir(filepath.Join(cur, ".git")) {
-			return cur
-		}
-		parent := filepath.Dir(cur)
-		if parent == cur {
-			return dir
-		}
-		cur = parent
-	}
-}
-
-func isDir(path string) bool {
-	info, err := os.Stat(path)
-	return err == nil && info.IsDir()
-}
-
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
-}
-
-func isSocket(path string) bool {
-	info, err := os.Stat(path)
-	if err != nil {
-		return false
-	}
-	return info.Mode().Type() == fs.ModeSocket
-}
-
-// startEditorProxy listens on a Unix socket inside sharedDir and spawns a
-// handler goroutine for each connection.  The returned listener and WaitGroup
-// let the caller perform a graceful shutdown.
-func startEditorProxy(sharedDir, codePath string) (net.Listener, *sync.WaitGroup, error) {
-	sockPath := filepath.Join(sharedDir, "editor.sock")
-	ln, err := net.Listen("unix", sockPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("listening on %s: %w", sockPath, err)
-	}
-	// Allow container user to connect.
-	os.Chmod(sockPath, 0666)
-
-	var wg sync.WaitGroup
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				return // listener closed
-			}
-			wg.Add(1)
-			go handleEditorConn(conn, sharedDir, codePath, &wg)
-		}
-	}()
-	return ln, &wg, nil
-}
-
-// handleEditorConn reads a filename from the connection, opens it in VS Code
-// on the host, then sends "done\n" when the editor closes.
-func handleEditorConn(conn net.Conn, sharedDir, codePath string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	defer conn.Close()
-
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
-		return
-	}
-	filename := scanner.Text()
-
-	// Sanitize: reject path traversal
-	if strings.Contains(filename, "/") || strings.Contains(filename, "\\") || filename == ".." {
-		return
-	}
-
-	filePath := filepath.Join(sharedDir, filename)
-	cmd := exec.Command(codePath, "--wait", filePath)
-	cmd.Stdout = os.Stderr // surface VS Code output
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "devcontainer: code --wait failed: %v\n", err)
-	}
-
-	conn.Write([]byte("done\n"))
-}
-
-// detectTimezone returns the host's IANA timezone (e.g. "America/New_York").
-// It checks TZ, /etc/timezone, then /etc/localtime in that order.
-func detectTimezone() string {
-	if tz := os.Getenv("TZ"); tz != "" {
-		return tz
-	}
-	if data, err := os.ReadFile("/etc/timezone"); err == nil {
-		if tz := strings.TrimSpace(string(data)); tz != "" {
-			return tz
-		}
-	}
-	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
-		const marker = "zoneinfo/"
-		if i := strings.LastIndex(target, marker); i != -1 {
-			return target[i+len(marker):]
-		}
-	}
-	return ""
-}
-